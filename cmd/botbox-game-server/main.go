@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"github.com/crestonbunch/botbox/common/game"
+	_ "github.com/crestonbunch/botbox/games/tron"
+	"github.com/crestonbunch/botbox/services/sandbox"
+	"log"
+	"os"
+	"strings"
+)
+
+// Serve any registered game, so that every game can share one server instead
+// of needing a main.go of its own, e.g.,
+// botbox-game-server --game tron --settings '{"width": 64}' --ids "1 2" \
+// --secrets "s1 s2"
+// In a Docker sandbox the game can be given in the BOTBOX_GAME environment
+// variable instead.
+func main() {
+	name := flag.String(
+		"game", os.Getenv(sandbox.ServerGameEnvVar),
+		"The game to serve, one of: "+strings.Join(game.Games(), ", "),
+	)
+	game.SetupFlags()
+
+	d, err := game.Lookup(*name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	game.RunGameServer(d)
+}
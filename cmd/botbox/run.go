@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/crestonbunch/botbox/common/game"
+	"github.com/crestonbunch/botbox/common/game/replay"
+	"github.com/crestonbunch/botbox/services/sandbox"
+	"golang.org/x/net/websocket"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long bots have to exit on their own after the match is over.
+const BotExitTimeout = 5 * time.Second
+
+// Play a match of a registered game on a random local port, with every bot
+// command started as a subprocess, e.g.,
+// botbox run --game tron "python3 bot.py" "python3 bot.py"
+// With --pipe the bots play over their stdin and stdout instead, and with
+// --teams "red blue red blue" they play in teams.
+func run(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	name := flags.String("game", "tron", "The registered game to play.")
+	seedFlag := flags.String("seed", "", "The seed for the match, random by default.")
+	doc := flags.String("settings", "", "A JSON document of game settings.")
+	dir := flags.String("out", "", "The directory to write recordings to, a new temporary directory by default.")
+	teamsFlag := flags.String("teams", "", "A space-delimited list of the team of every bot.")
+	pipe := flags.Bool("pipe", false, "Play over the stdin and stdout of the bots instead of websockets.")
+	verbose := flags.Bool("verbose", false, "Show the game server log.")
+	flags.Parse(args)
+	bots := flags.Args()
+
+	def, err := game.Lookup(*name)
+	if err != nil {
+		return err
+	}
+	if len(bots) != def.Players {
+		return errors.New("Game " + def.Name + " needs " +
+			strconv.Itoa(def.Players) + " bot commands.")
+	}
+	settings, err := def.ParseSettings([]byte(*doc))
+	if err != nil {
+		return err
+	}
+	var teams game.Teams
+	if *teamsFlag != "" {
+		teams = strings.Fields(*teamsFlag)
+	}
+
+	ids := make([]string, len(bots))
+	for i := range bots {
+		ids[i] = "bot" + strconv.Itoa(i+1)
+	}
+	secrets, err := sandbox.GenerateSecrets(len(bots))
+	if err != nil {
+		return err
+	}
+
+	var seed int64
+	if *seedFlag != "" {
+		seed, err = strconv.ParseInt(*seedFlag, 10, 64)
+		if err != nil {
+			return errors.New("Seed must be an integer.")
+		}
+	} else {
+		seed, err = sandbox.GenerateSeed()
+		if err != nil {
+			return err
+		}
+	}
+
+	if *dir == "" {
+		*dir, err = ioutil.TempDir("", "botbox")
+		if err != nil {
+			return err
+		}
+	}
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	// listen on a random port so that matches can run side by side
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	var output sync.WaitGroup
+	cmds := make([]*exec.Cmd, len(bots))
+	for i, bot := range bots {
+		cmds[i], err = newBot(ids[i], bot, *pipe, &output)
+		if err != nil {
+			return err
+		}
+	}
+
+	spectator := game.NewSpectator(def.SpectatorDelay)
+	match := game.Match{
+		Ids:      ids,
+		Secrets:  secrets,
+		Seed:     seed,
+		Settings: settings,
+		Teams:    teams,
+		Dir:      *dir,
+	}
+	mux := http.NewServeMux()
+	mux.Handle(game.SpectatePath, spectator.Handler())
+
+	var done <-chan struct{}
+	if *pipe {
+		done, err = def.Pipe(context.Background(), match, spectator, cmds)
+		if err != nil {
+			stopBots(cmds)
+			return err
+		}
+		go http.Serve(listener, mux)
+		fmt.Println("Playing " + def.Name + " over pipes with seed " +
+			strconv.FormatInt(seed, 10))
+		fmt.Println("Spectate at ws://" + addr + game.SpectatePath)
+	} else {
+		var handler websocket.Server
+		handler, done, err = def.Handler(context.Background(), match, spectator)
+		if err != nil {
+			return err
+		}
+		mux.Handle("/", handler)
+		go http.Serve(listener, mux)
+		fmt.Println("Playing " + def.Name + " on " + addr + " with seed " +
+			strconv.FormatInt(seed, 10))
+		fmt.Println("Spectate at ws://" + addr + game.SpectatePath)
+
+		for i, cmd := range cmds {
+			cmd.Env = append(os.Environ(),
+				sandbox.ClientServerEnvVar+"="+addr,
+				sandbox.ClientSecretEnvVar+"="+secrets[i],
+			)
+			err = cmd.Start()
+			if err != nil {
+				stopBots(cmds)
+				return err
+			}
+		}
+	}
+
+	<-done
+	stopBots(cmds)
+	output.Wait()
+
+	file := path.Join(*dir, sandbox.ReplayFile)
+	r, err := replay.Open(file)
+	if err != nil {
+		return err
+	}
+	fmt.Println("")
+	if r.Footer == nil || r.Footer.Result == nil {
+		fmt.Println("The match did not finish.")
+	} else {
+		for i, result := range r.Footer.Result {
+			line := botName(r.Header.Players, i, ids, bots) + ": " +
+				resultName(result)
+			if i < len(r.Footer.Results) {
+				line += ", " + placeName(r.Footer.Results[i])
+			}
+			fmt.Println(line)
+		}
+	}
+	fmt.Println("Replay: " + file)
+
+	return nil
+}
+
+// Name the bot that played as player p, which is the client id the replay
+// recorded for the player.
+func botName(players []string, p int, ids, bots []string) string {
+	if p < len(players) {
+		for i, id := range ids {
+			if id == players[p] {
+				return id + " (" + bots[i] + ")"
+			}
+		}
+	}
+	return "player " + strconv.Itoa(p+1)
+}
+
+// Create the command of a bot, streaming its output with the bot id as a
+// prefix. Bots that play over pipes use their stdout for the game, so only
+// their stderr is streamed.
+func newBot(
+	id, command string, pipe bool, output *sync.WaitGroup,
+) (*exec.Cmd, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, errors.New("Bot command for " + id + " is empty.")
+	}
+
+	cmd := exec.Command(fields[0], fields[1:]...)
+	if !pipe {
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		output.Add(1)
+		go prefix(id, stdout, os.Stdout, output)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	output.Add(1)
+	go prefix(id, stderr, os.Stderr, output)
+
+	return cmd, nil
+}
+
+// Copy every line from r to w with a prefix.
+func prefix(id string, r io.Reader, w io.Writer, output *sync.WaitGroup) {
+	defer output.Done()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fmt.Fprintln(w, "["+id+"] "+scanner.Text())
+	}
+}
+
+// Give the bots a chance to exit after the server closes their connections,
+// and kill any that do not. Bots that were never started are skipped.
+func stopBots(cmds []*exec.Cmd) {
+	var wg sync.WaitGroup
+	for _, cmd := range cmds {
+		if cmd.Process == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(cmd *exec.Cmd) {
+			defer wg.Done()
+
+			done := make(chan error, 1)
+			go func() { done <- cmd.Wait() }()
+			select {
+			case <-done:
+			case <-time.After(BotExitTimeout):
+				cmd.Process.Kill()
+				<-done
+			}
+		}(cmd)
+	}
+	wg.Wait()
+}
+
+func resultName(result int) string {
+	switch result {
+	case game.ResultWin:
+		return "win"
+	case game.ResultLoss:
+		return "loss"
+	}
+	return "tie"
+}
+
+// Describe the place of a player, with its score and the reason for its
+// result if the game gave them.
+func placeName(result replay.Result) string {
+	name := "place " + strconv.Itoa(result.Place)
+	if result.Team != "" {
+		name += ", team " + result.Team
+	}
+	if result.Score != nil {
+		name += ", score " + strconv.FormatFloat(*result.Score, 'g', -1, 64)
+	}
+	if result.Reason != "" {
+		name += ", " + result.Reason
+	}
+	return name
+}
@@ -0,0 +1,204 @@
+package game
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/crestonbunch/botbox/common/game/replay"
+	"github.com/crestonbunch/botbox/services/sandbox"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+)
+
+// A line of the action log. The first line holds the seed, followed by the
+// teams if the match is played in teams. Then there is a line for every turn
+// with the actions and a hash of the resulting state, and the last line holds
+// the result if the game finished, along with the reason it was adjudicated if
+// it was stopped at a limit.
+type actionLogEntry struct {
+	Seed        *int64            `json:"seed,omitempty"`
+	Teams       Teams             `json:"teams,omitempty"`
+	Turn        *int              `json:"turn,omitempty"`
+	Actions     []json.RawMessage `json:"actions,omitempty"`
+	State       string            `json:"state,omitempty"`
+	Result      []int             `json:"result,omitempty"`
+	Results     []replay.Result   `json:"results,omitempty"`
+	Adjudicated string            `json:"adjudicated,omitempty"`
+}
+
+// An action recorder logs the actions every player committed on every turn,
+// including empty actions from timeouts, so that the game can be verified
+// later by playing the actions again.
+type ActionRecorder struct {
+	ActionLog *os.File
+	enc       *json.Encoder
+}
+
+// Create a new action recorder that writes to the action log in the given
+// directory. The seed of the match is needed to play the game again.
+func NewActionRecorder(dir string, seed int64) (*ActionRecorder, error) {
+	f := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	file, err := os.OpenFile(path.Join(dir, sandbox.ActionLogFile), f, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ActionRecorder{file, json.NewEncoder(file)}
+	err = r.enc.Encode(actionLogEntry{Seed: &seed})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Log the teams of a match, so that the game can be played again in the same
+// teams. Must be called before any turn is recorded.
+func (r *ActionRecorder) SetTeams(teams Teams) error {
+	return r.enc.Encode(actionLogEntry{Teams: teams})
+}
+
+func (r *ActionRecorder) LogTurn(t Turn) error {
+	return r.enc.Encode(actionLogEntry{
+		Turn:    &t.Number,
+		Actions: t.Actions,
+		State:   hashJSON(t.State),
+	})
+}
+
+func (r *ActionRecorder) LogResult(s GameState) error {
+	entry := actionLogEntry{Result: s.Result(), Results: Rank(s)}
+	if a, ok := s.(*AdjudicatedState); ok {
+		entry.Adjudicated = a.Reason
+	}
+	return r.enc.Encode(entry)
+}
+
+func (r *ActionRecorder) LogConnection(c GameClient) error {
+	return nil
+}
+
+func (r *ActionRecorder) LogDisconnection(c GameClient) error {
+	return nil
+}
+
+func (r *ActionRecorder) LogReconnection(c GameClient) error {
+	return nil
+}
+
+func (r *ActionRecorder) LogViolation(c GameClient, v ViolationError) error {
+	return nil
+}
+
+func (r *ActionRecorder) Close() error {
+	return r.ActionLog.Close()
+}
+
+func hashState(s GameState) (string, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+
+	return hashJSON(b), nil
+}
+
+// Hash a state that is already encoded as JSON.
+func hashJSON(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify an action log by committing the logged actions to a fresh state made
+// with the seed of the match, and checking every resulting state and the result
+// against the log. Turn-based states only commit the action of the player
+// whose turn it is, just like the turn-based state manager. Matches played in
+// teams are played again in the same teams, and matches that were stopped at a
+// limit are adjudicated again. Returns an error describing the first difference
+// found. Logs that end without a result, e.g., because they were cut short, do
+// not verify.
+func Verify(newState func(seed int64) GameState, log io.Reader) error {
+	dec := json.NewDecoder(bufio.NewReader(log))
+
+	var header actionLogEntry
+	err := dec.Decode(&header)
+	if err != nil {
+		return err
+	}
+	if header.Seed == nil {
+		return errors.New("Action log does not start with a seed.")
+	}
+	state := newState(*header.Seed)
+	result := false
+
+	for {
+		var entry actionLogEntry
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			if !result {
+				return errors.New("Action log does not have a result.")
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if entry.Teams != nil {
+			err := SetTeams(state, len(entry.Teams), entry.Teams)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if entry.Result != nil {
+			if entry.Adjudicated != "" && !state.Finished() {
+				// the match was stopped at a limit, so decide the result the same
+				// way
+				state = Adjudicate(state, len(entry.Result), entry.Adjudicated)
+			} else if entry.Adjudicated != "" {
+				return errors.New("Game is over, but the log has an adjudicated result.")
+			}
+			if !state.Finished() {
+				return errors.New("Game is not over, but the log has a result.")
+			}
+			if !reflect.DeepEqual(entry.Result, state.Result()) {
+				return errors.New("Result does not match the log.")
+			}
+			if entry.Results != nil && !reflect.DeepEqual(entry.Results, Rank(state)) {
+				return errors.New("Places do not match the log.")
+			}
+			result = true
+			continue
+		}
+		if entry.Turn == nil {
+			return errors.New("Action log has an unknown line.")
+		}
+		turn := strconv.Itoa(*entry.Turn)
+
+		if s, ok := state.(TurnBasedGameState); ok {
+			p := s.Turn()
+			if p >= len(entry.Actions) {
+				return errors.New("Turn " + turn + " is missing actions.")
+			}
+			Commit(state, p, entry.Actions[p])
+		} else {
+			for p, a := range entry.Actions {
+				Commit(state, p, a)
+			}
+		}
+
+		hash, err := hashState(state)
+		if err != nil {
+			return err
+		}
+		if hash != entry.State {
+			return errors.New("Turn " + turn + " state does not match the log.")
+		}
+	}
+}
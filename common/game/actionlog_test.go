@@ -0,0 +1,170 @@
+package game
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/crestonbunch/botbox/services/sandbox"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// Record a synchronized mock game where player 1 times out on the second
+// turn, and return the action log.
+func recordMockGame(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "actions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewActionRecorder(dir, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := mockTwoPlayerGame()
+	for i := 0; !state.Finished(); i++ {
+		actions := []json.RawMessage{StringAction("1"), StringAction("3")}
+		if i == 1 {
+			actions[0] = nil
+		}
+		for p, a := range actions {
+			Commit(state, p, a)
+		}
+		r.LogTurn(takeTurn(i, actions, nil, state))
+	}
+	r.LogResult(state)
+	r.Close()
+
+	b, err := ioutil.ReadFile(path.Join(dir, sandbox.ActionLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestActionRecorder(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(recordMockGame(t)), "\n")
+
+	if len(lines) != 6 {
+		t.Fatal("Action log does not have a seed, 4 turns and a result")
+	}
+	if lines[0] != `{"seed":42}` {
+		t.Error("Action log does not start with the seed")
+	}
+	if !strings.HasPrefix(lines[2], `{"turn":1,"actions":[null,"3"],"state":"`) {
+		t.Error("Empty action was not logged: " + lines[2])
+	}
+	if lines[5] != `{"result":[-1,1],"results":[{"place":2},{"place":1}]}` {
+		t.Error("Action log does not end with the result")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	log := recordMockGame(t)
+	seed := int64(0)
+	newState := func(s int64) GameState {
+		seed = s
+		return mockTwoPlayerGame()
+	}
+
+	err := Verify(newState, strings.NewReader(log))
+	if err != nil {
+		t.Error(err)
+	}
+	if seed != 42 {
+		t.Error("State was not made with the logged seed")
+	}
+
+	tampered := strings.Replace(log, `[null,"3"]`, `["2","3"]`, 1)
+	err = Verify(newState, strings.NewReader(tampered))
+	if err == nil || err.Error() != "Turn 1 state does not match the log." {
+		t.Error("Tampered action was not detected")
+	}
+
+	tampered = strings.Replace(log, `{"result":[-1,1]`, `{"result":[1,-1]`, 1)
+	err = Verify(newState, strings.NewReader(tampered))
+	if err == nil {
+		t.Error("Tampered result was not detected")
+	}
+
+	tampered = strings.Replace(log, `{"place":2},{"place":1}`, `{"place":1},{"place":1}`, 1)
+	err = Verify(newState, strings.NewReader(tampered))
+	if err == nil || err.Error() != "Places do not match the log." {
+		t.Error("Tampered places were not detected")
+	}
+
+	lines := strings.SplitAfter(log, "\n")
+	stripped := strings.Join(lines[:len(lines)-2], "")
+	err = Verify(newState, strings.NewReader(stripped))
+	if err == nil || err.Error() != "Action log does not have a result." {
+		t.Error("Log without a result was verified")
+	}
+	truncated := strings.Join(lines[:3], "")
+	err = Verify(newState, strings.NewReader(truncated))
+	if err == nil {
+		t.Error("Truncated log was verified")
+	}
+}
+
+func TestVerifyTurnBased(t *testing.T) {
+	buf := new(bytes.Buffer)
+	r := &ActionRecorder{nil, json.NewEncoder(buf)}
+	seed := int64(0)
+	r.enc.Encode(actionLogEntry{Seed: &seed})
+
+	state := &mockTurnState{mockState{[]int{0, 0}}, 0}
+	for i := 0; !state.Finished(); i++ {
+		p := state.Turn()
+		actions := make([]json.RawMessage, 2)
+		actions[p] = StringAction("3")
+		Commit(state, p, actions[p])
+		r.LogTurn(takeTurn(i, actions, nil, state))
+	}
+	r.LogResult(state)
+
+	err := Verify(func(int64) GameState {
+		return &mockTurnState{mockState{[]int{0, 0}}, 0}
+	}, buf)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyAdjudicated(t *testing.T) {
+	buf := new(bytes.Buffer)
+	r := &ActionRecorder{nil, json.NewEncoder(buf)}
+	seed := int64(0)
+	r.enc.Encode(actionLogEntry{Seed: &seed})
+
+	state := &mockAdjudicatorState{mockState{[]int{0, 0}}}
+	actions := []json.RawMessage{StringAction("3"), StringAction("1")}
+	for i := 0; i < 2; i++ {
+		Commit(state, 0, actions[0])
+		Commit(state, 1, actions[1])
+		r.LogTurn(takeTurn(i, actions, nil, state))
+	}
+	r.LogResult(Adjudicate(state, 2, AdjudicatedTurns))
+	log := buf.String()
+
+	newState := func(int64) GameState {
+		return &mockAdjudicatorState{mockState{[]int{0, 0}}}
+	}
+	err := Verify(newState, strings.NewReader(log))
+	if err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(log, `"adjudicated":"turn limit reached"`) {
+		t.Error("Action log does not have the adjudication reason")
+	}
+
+	tampered := strings.Replace(log, `"adjudicated":"turn limit reached"`, `"adjudicated":""`, 1)
+	err = Verify(newState, strings.NewReader(tampered))
+	if err == nil {
+		t.Error("Result of an unfinished game was verified")
+	}
+}
@@ -0,0 +1,138 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// Game states commit actions by implementing one of the actor interfaces
+// below. Actions travel from clients as raw JSON, and the state decides how to
+// decode them.
+
+// Games with simple actions can take them as plain strings. The action must be
+// sent as a JSON string, and the empty string is committed if the client did
+// not send one.
+type StringActor interface {
+	Do(p int, a string)
+}
+
+// Games can take the raw JSON of an action and decode it themselves. The
+// action is nil if the client did not send one.
+type JSONActor interface {
+	DoJSON(p int, a json.RawMessage) error
+}
+
+// Games can define their own action type. The action is decoded into the value
+// returned by NewAction, which must be a pointer, before it is committed. If
+// the client did not send an action, then the zero value is committed.
+type TypedActor interface {
+	NewAction() interface{}
+	DoAction(p int, a interface{}) error
+}
+
+// Sent as a client error when the game can not decode the action a client sent.
+type MalformedActionError struct {
+	Err error
+}
+
+func (e MalformedActionError) Error() string {
+	return "Malformed action: " + e.Err.Error()
+}
+
+// Commit an action sent as JSON for player p. If the action can not be decoded,
+// then the empty action is committed instead and a MalformedActionError is
+// returned so that the client can be told what went wrong. If the game rejects
+// the action, then an IllegalActionError is returned.
+func Commit(s GameState, p int, a json.RawMessage) error {
+	if isEmptyAction(a) {
+		a = nil
+	}
+
+	// prefer the richest interface the state implements
+	switch actor := s.(type) {
+	case TypedActor:
+		action := actor.NewAction()
+		if a != nil {
+			err := json.Unmarshal(a, action)
+			if err != nil {
+				if err := actor.DoAction(p, actor.NewAction()); err != nil {
+					return IllegalActionError{err}
+				}
+				return MalformedActionError{err}
+			}
+		}
+		return illegal(actor.DoAction(p, action))
+	case JSONActor:
+		if a != nil && !json.Valid(a) {
+			actor.DoJSON(p, nil)
+			return MalformedActionError{errors.New("Invalid JSON.")}
+		}
+		return illegal(actor.DoJSON(p, a))
+	case StringActor:
+		action := ""
+		var err error
+		if a != nil {
+			err = json.Unmarshal(a, &action)
+			if err != nil {
+				action = ""
+				err = MalformedActionError{err}
+			} else if v, ok := s.(Validator); ok && !v.Validate(p, action) {
+				err = IllegalActionError{errNotAllowed}
+			}
+		}
+		actor.Do(p, action)
+		return err
+	}
+
+	return errors.New("Game state does not accept actions.")
+}
+
+func illegal(err error) error {
+	if err != nil {
+		return IllegalActionError{err}
+	}
+	return nil
+}
+
+// Commit an action for the player of client c during a turn, and report any
+// error on the error channel and back to the client.
+func commit(
+	ctx context.Context, s GameState, p int, c GameClient, a json.RawMessage,
+	turn int, errChan chan error,
+) {
+	err := Commit(s, p, a)
+	if err == nil {
+		return
+	}
+
+	msg := ServerMessage{
+		Type:    MessageWarning,
+		Turn:    turn,
+		Player:  p,
+		Message: err.Error(),
+	}
+	switch err.(type) {
+	case MalformedActionError:
+		msg.Code = ErrorMalformedJSON
+	case IllegalActionError:
+		msg.Code = ErrorIllegalAction
+	default:
+		report(ctx, errChan, err)
+		return
+	}
+	report(ctx, errChan, ClientError{err, c})
+	notify(c, msg)
+}
+
+// Encode a string action as JSON, for games and clients that use plain
+// string actions.
+func StringAction(a string) json.RawMessage {
+	b, _ := json.Marshal(a)
+	return b
+}
+
+// An action is empty if the client did not send one, or sent null.
+func isEmptyAction(a json.RawMessage) bool {
+	return len(a) == 0 || string(a) == "null"
+}
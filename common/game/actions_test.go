@@ -0,0 +1,154 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCommitStringAction(t *testing.T) {
+	state := mockTwoPlayerGame()
+
+	err := Commit(state, 0, StringAction("3"))
+	if err != nil {
+		t.Error(err)
+	}
+	err = Commit(state, 1, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	err = Commit(state, 1, json.RawMessage(`3`))
+	if _, ok := err.(MalformedActionError); !ok {
+		t.Error("Number was accepted as a string action")
+	}
+
+	if state.Players[0] != 3 || state.Players[1] != 0 {
+		t.Error("String actions were not committed")
+	}
+}
+
+func TestCommitJSONAction(t *testing.T) {
+	state := &mockJSONState{}
+
+	err := Commit(state, 0, json.RawMessage(`{"unit": 1}`))
+	if err != nil {
+		t.Error(err)
+	}
+	err = Commit(state, 0, json.RawMessage(`{"unit": `))
+	if _, ok := err.(MalformedActionError); !ok {
+		t.Error("Invalid JSON was not reported")
+	}
+
+	if len(state.actions) != 2 {
+		t.Fatal("Actions were not committed")
+	}
+	if string(state.actions[0]) != `{"unit": 1}` || state.actions[1] != nil {
+		t.Error("Actions were not passed through as raw JSON")
+	}
+}
+
+func TestCommitTypedAction(t *testing.T) {
+	state := &mockTypedState{}
+
+	err := Commit(state, 0, json.RawMessage(`{"unit": 1, "target": "a"}`))
+	if err != nil {
+		t.Error(err)
+	}
+	err = Commit(state, 1, json.RawMessage(`{"unit": "one"}`))
+	if _, ok := err.(MalformedActionError); !ok {
+		t.Error("Action of the wrong type was not reported")
+	}
+	err = Commit(state, 1, json.RawMessage(`null`))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(state.actions) != 3 {
+		t.Fatal("Actions were not committed")
+	}
+	if state.actions[0] != (mockAction{1, "a"}) {
+		t.Error("Action was not decoded")
+	}
+	if state.actions[1] != (mockAction{}) || state.actions[2] != (mockAction{}) {
+		t.Error("Empty action was not committed")
+	}
+}
+
+func TestSynchronizedMalformedAction(t *testing.T) {
+	state := mockTwoPlayerGame()
+	turnChan := make(chan Turn)
+	errChan := make(chan error)
+	stateMan := NewSynchronizedStateManager(state, time.Second)
+
+	clients := []GameClient{
+		stateMan.NewClient("1", nil),
+		stateMan.NewClient("2", nil),
+	}
+
+	wg := stateMan.Play(context.Background(), clients, turnChan, errChan)
+
+	go func() {
+		<-clients[0].Send()
+		<-clients[1].Send()
+		clients[0].Receive() <- ClientMessage{json.RawMessage(`{}`)}
+		clients[1].Receive() <- ClientMessage{StringAction("3")}
+
+		err := <-errChan
+		if e, ok := err.(ClientError); !ok || e.client != clients[0] {
+			t.Error("Malformed action was not reported as a client error")
+		} else if _, ok := e.err.(MalformedActionError); !ok {
+			t.Error("Client error is not a malformed action")
+		}
+		msg := <-clients[0].Send()
+		if msg.Type != MessageWarning || msg.Code != ErrorMalformedJSON {
+			t.Error("Player 1 was not warned about its malformed action")
+		}
+		<-turnChan
+
+		for i := 0; i < 3; i++ {
+			<-clients[0].Send()
+			<-clients[1].Send()
+			clients[0].Receive() <- ClientMessage{StringAction("1")}
+			clients[1].Receive() <- ClientMessage{StringAction("3")}
+			<-turnChan
+		}
+	}()
+
+	wg.Wait()
+
+	if state.Players[0] != 3 || state.Players[1] != 12 {
+		t.Error("Malformed action was not skipped")
+	}
+}
+
+// A mock game that takes raw JSON actions.
+type mockJSONState struct {
+	mockState
+	actions []json.RawMessage
+}
+
+func (s *mockJSONState) DoJSON(p int, a json.RawMessage) error {
+	s.actions = append(s.actions, a)
+	return nil
+}
+
+type mockAction struct {
+	Unit   int    `json:"unit"`
+	Target string `json:"target"`
+}
+
+// A mock game with its own action type.
+type mockTypedState struct {
+	mockState
+	actions []mockAction
+}
+
+func (s *mockTypedState) NewAction() interface{} {
+	return &mockAction{}
+}
+
+func (s *mockTypedState) DoAction(p int, a interface{}) error {
+	s.actions = append(s.actions, *a.(*mockAction))
+	return nil
+}
@@ -0,0 +1,263 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// The most turns a game in an arena may last by default before it is stopped.
+const ArenaMaxTurns = 10000
+
+// An agent plays a game in the same process as the state, without the
+// connection and JSON encoding that bots need. It is asked for an action the
+// same way a bot is sent a state message.
+type Agent interface {
+	// Choose an action for player p, given the actions it can make and its view
+	// of the state. The action is committed with CommitAction.
+	Act(p int, actions interface{}, view interface{}) interface{}
+}
+
+// Use an ordinary function as an agent.
+type AgentFunc func(p int, actions interface{}, view interface{}) interface{}
+
+func (f AgentFunc) Act(p int, actions interface{}, view interface{}) interface{} {
+	return f(p, actions, view)
+}
+
+// Commit an action chosen by an agent for player p. String actors take a
+// string, typed actors take a value of the type returned by NewAction, and
+// JSON actors take anything that can be encoded as JSON. A nil action is the
+// same as a client not sending one. Raw JSON is committed with Commit, just
+// like an action sent by a client. Returns the same errors as Commit.
+func CommitAction(s GameState, p int, a interface{}) error {
+	if raw, ok := a.(json.RawMessage); ok {
+		return Commit(s, p, raw)
+	}
+
+	switch actor := s.(type) {
+	case TypedActor:
+		if a == nil {
+			a = actor.NewAction()
+		}
+		return illegal(actor.DoAction(p, a))
+	case JSONActor:
+		if a == nil {
+			return illegal(actor.DoJSON(p, nil))
+		}
+		b, err := json.Marshal(a)
+		if err != nil {
+			actor.DoJSON(p, nil)
+			return MalformedActionError{err}
+		}
+		return illegal(actor.DoJSON(p, b))
+	case StringActor:
+		action, ok := a.(string)
+		var err error
+		if a != nil && !ok {
+			err = MalformedActionError{errors.New("Action is not a string.")}
+		} else if v, ok := s.(Validator); ok && a != nil && !v.Validate(p, action) {
+			err = IllegalActionError{errNotAllowed}
+		}
+		actor.Do(p, action)
+		return err
+	}
+
+	return errors.New("Game state does not accept actions.")
+}
+
+// Statistics of games played by agents. Every slice is indexed by player.
+type Stats struct {
+	Games  int
+	Turns  int
+	Wins   []int
+	Ties   []int
+	Losses []int
+	// The number of actions the game rejected as malformed or illegal.
+	Rejected []int
+	// The number of games stopped at the turn limit and adjudicated.
+	Adjudicated int
+}
+
+func newStats(players int) *Stats {
+	return &Stats{
+		Wins:     make([]int, players),
+		Ties:     make([]int, players),
+		Losses:   make([]int, players),
+		Rejected: make([]int, players),
+	}
+}
+
+// Add the statistics of other games.
+func (s *Stats) Add(o *Stats) {
+	s.Games += o.Games
+	s.Turns += o.Turns
+	s.Adjudicated += o.Adjudicated
+	for i := range s.Wins {
+		s.Wins[i] += o.Wins[i]
+		s.Ties[i] += o.Ties[i]
+		s.Losses[i] += o.Losses[i]
+		s.Rejected[i] += o.Rejected[i]
+	}
+}
+
+// Return the fraction of games player p won.
+func (s *Stats) WinRate(p int) float64 {
+	if s.Games == 0 {
+		return 0
+	}
+	return float64(s.Wins[p]) / float64(s.Games)
+}
+
+// Play a single game between agents, given in player order, until it is over.
+// Turn-based states ask only the player whose turn it is for an action, like
+// the turn-based state manager. Every other state asks every player at once
+// and commits the actions in player order, like the synchronized state
+// manager. Rejected actions are counted, not returned, since a bot would only
+// be warned about them. Games that are not over after maxTurns turns are
+// stopped and adjudicated, like matches at their turn limit. A maxTurns of zero
+// lets the game go on until it is over.
+func Simulate(state GameState, agents []Agent, maxTurns int) (*Stats, error) {
+	stats := newStats(len(agents))
+	turnBased, isTurnBased := state.(TurnBasedGameState)
+	limits := Limits{Turns: maxTurns}
+
+	for ; !state.Finished(); stats.Turns++ {
+		players := make([]int, len(agents))
+		for i := range players {
+			players[i] = i
+		}
+		if isTurnBased {
+			players = []int{turnBased.Turn()}
+		}
+
+		// every agent chooses before any action is committed, so that all
+		// players see the same state
+		actions := make([]interface{}, len(agents))
+		for _, i := range players {
+			actions[i] = agents[i].Act(i, state.Actions(i), state.View(i))
+		}
+		for _, i := range players {
+			err := stats.commit(state, i, actions[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if s, stop := limits.check(state, len(agents), stats.Turns, time.Time{}); stop {
+			state = s
+			stats.Adjudicated++
+		}
+	}
+
+	stats.Games = 1
+	for i, r := range state.Result() {
+		switch r {
+		case ResultWin:
+			stats.Wins[i]++
+		case ResultLoss:
+			stats.Losses[i]++
+		default:
+			stats.Ties[i]++
+		}
+	}
+
+	return stats, nil
+}
+
+// Commit an action, counting it if the game rejects it.
+func (s *Stats) commit(state GameState, p int, a interface{}) error {
+	err := CommitAction(state, p, a)
+	switch err.(type) {
+	case nil:
+	case MalformedActionError, IllegalActionError:
+		s.Rejected[p]++
+	default:
+		return err
+	}
+	return nil
+}
+
+// An arena plays many games between agents at once to quickly measure how
+// well they play against each other.
+type Arena struct {
+	// Create the initial state of a game. All random numbers must be drawn from
+	// the given generator, which is seeded for the game.
+	NewState func(rng *rand.Rand) GameState
+	// Create the agents for a game in player order. Every game gets its own
+	// agents, so they may keep state between turns.
+	NewAgents func(game int) []Agent
+	// The number of games to play.
+	Games int
+	// The number of games played at once. Defaults to the number of CPUs.
+	Parallel int
+	// The most turns a game may last before it is stopped and adjudicated.
+	// Defaults to ArenaMaxTurns.
+	MaxTurns int
+	// Game i is played with the seed Seed + i, so that the same arena plays the
+	// same games every time.
+	Seed int64
+}
+
+// Play every game and return the statistics of all of them. Stops at the first
+// error.
+func (a *Arena) Play() (*Stats, error) {
+	parallel := a.Parallel
+	if parallel <= 0 {
+		parallel = runtime.GOMAXPROCS(0)
+	}
+	maxTurns := a.MaxTurns
+	if maxTurns <= 0 {
+		maxTurns = ArenaMaxTurns
+	}
+
+	games := make(chan int)
+	var stats *Stats
+	var err error
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(parallel)
+	for w := 0; w < parallel; w++ {
+		go func() {
+			defer wg.Done()
+			for g := range games {
+				state := a.NewState(NewRand(a.Seed + int64(g)))
+				s, e := Simulate(state, a.NewAgents(g), maxTurns)
+
+				mutex.Lock()
+				if e != nil && err == nil {
+					err = e
+				} else if e == nil && stats == nil {
+					stats = s
+				} else if e == nil {
+					stats.Add(s)
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for g := 0; g < a.Games; g++ {
+		mutex.Lock()
+		failed := err != nil
+		mutex.Unlock()
+		if failed {
+			break
+		}
+		games <- g
+	}
+	close(games)
+	wg.Wait()
+
+	if err != nil {
+		return nil, err
+	}
+	if stats == nil {
+		return nil, errors.New("Arena did not play any games.")
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,199 @@
+package game
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func constantAgent(a interface{}) Agent {
+	return AgentFunc(func(p int, actions interface{}, view interface{}) interface{} {
+		return a
+	})
+}
+
+func TestCommitAction(t *testing.T) {
+	state := &mockState{[]int{0, 0}}
+	err := CommitAction(state, 0, "3")
+	if err != nil || state.Players[0] != 3 {
+		t.Error("String action was not committed")
+	}
+	err = CommitAction(state, 0, 3)
+	if _, ok := err.(MalformedActionError); !ok {
+		t.Error("Action that is not a string was not malformed")
+	}
+	err = CommitAction(state, 1, StringAction("2"))
+	if err != nil || state.Players[1] != 2 {
+		t.Error("Raw JSON action was not committed")
+	}
+
+	typed := &mockTypedState{mockState: mockState{[]int{0, 0}}}
+	err = CommitAction(typed, 0, &mockAction{Unit: 4, Target: "north"})
+	if err != nil || typed.actions[0].Unit != 4 {
+		t.Error("Typed action was not committed")
+	}
+	err = CommitAction(typed, 0, nil)
+	if err != nil || typed.actions[1].Unit != 0 {
+		t.Error("Empty typed action was not committed")
+	}
+
+	j := &mockJSONState{mockState: mockState{[]int{0, 0}}}
+	err = CommitAction(j, 0, map[string]int{"unit": 2})
+	if err != nil || string(j.actions[0]) != `{"unit":2}` {
+		t.Error("JSON action was not committed")
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	state := &mockState{[]int{0, 0}}
+	stats, err := Simulate(state, []Agent{constantAgent("1"), constantAgent("3")}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Games != 1 || stats.Turns != 4 {
+		t.Error("Game was not played for 4 turns")
+	}
+	if stats.Losses[0] != 1 || stats.Wins[1] != 1 {
+		t.Error("Player 2 did not win")
+	}
+}
+
+func TestSimulateTurnBased(t *testing.T) {
+	state := &mockTurnState{mockState{[]int{0, 0}}, 0}
+	asked := []int{0, 0}
+	agent := AgentFunc(func(p int, actions interface{}, view interface{}) interface{} {
+		asked[p]++
+		if state.Turn() != p {
+			t.Error("Agent was asked out of turn")
+		}
+		return "3"
+	})
+	stats, err := Simulate(state, []Agent{agent, agent}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// player 1 reaches 12 on the 7th turn
+	if stats.Turns != 7 || asked[0] != 4 || asked[1] != 3 {
+		t.Error("Players did not take turns")
+	}
+	if stats.Wins[0] != 1 || stats.Losses[1] != 1 {
+		t.Error("Player 1 did not win")
+	}
+}
+
+func TestSimulateRejected(t *testing.T) {
+	state := &mockState{[]int{0, 0}}
+	stats, err := Simulate(state, []Agent{constantAgent(1), constantAgent("3")}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Rejected[0] != stats.Turns || stats.Rejected[1] != 0 {
+		t.Error("Rejected actions were not counted")
+	}
+}
+
+func TestArena(t *testing.T) {
+	arena := &Arena{
+		NewState: func(rng *rand.Rand) GameState {
+			return &mockRandomState{mockState{[]int{0, 0}}, rng}
+		},
+		NewAgents: func(game int) []Agent {
+			return []Agent{constantAgent("1"), constantAgent("1")}
+		},
+		Games:    200,
+		Parallel: 8,
+		Seed:     3,
+	}
+	stats, err := arena.Play()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Games != 200 {
+		t.Fatal("Arena did not play 200 games")
+	}
+	for p := 0; p < 2; p++ {
+		if stats.Wins[p]+stats.Ties[p]+stats.Losses[p] != 200 {
+			t.Error("Results do not add up to the number of games")
+		}
+	}
+	if stats.Wins[0] == 0 || stats.Wins[1] == 0 {
+		t.Error("Random games were all won by one player")
+	}
+
+	again, err := arena.Play()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Wins[0] != stats.Wins[0] || again.Turns != stats.Turns {
+		t.Error("The same seeds gave different games")
+	}
+	if stats.WinRate(0) != float64(stats.Wins[0])/200 {
+		t.Error("Win rate is wrong")
+	}
+}
+
+func TestArenaMaxTurns(t *testing.T) {
+	arena := &Arena{
+		NewState: func(rng *rand.Rand) GameState {
+			return &mockAdjudicatorState{mockState{[]int{0, 0}}}
+		},
+		NewAgents: func(game int) []Agent {
+			// the second agent never scores, and the first only scores once
+			first := "1"
+			return []Agent{
+				AgentFunc(func(p int, actions interface{}, view interface{}) interface{} {
+					a := first
+					first = "0"
+					return a
+				}),
+				constantAgent("0"),
+			}
+		},
+		Games:    4,
+		MaxTurns: 50,
+	}
+	stats, err := arena.Play()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Games != 4 || stats.Adjudicated != 4 || stats.Turns != 200 {
+		t.Error("Games that never finish were not stopped after 50 turns")
+	}
+	if stats.Wins[0] != 4 || stats.Losses[1] != 4 {
+		t.Error("Stopped games were not adjudicated")
+	}
+}
+
+func TestArenaError(t *testing.T) {
+	arena := &Arena{
+		NewState: func(rng *rand.Rand) GameState {
+			return &mockNoActorState{}
+		},
+		NewAgents: func(game int) []Agent {
+			return []Agent{constantAgent("1")}
+		},
+		Games: 10,
+	}
+	_, err := arena.Play()
+	if err == nil {
+		t.Error("Arena did not return the game error")
+	}
+}
+
+// A mock game that does not accept actions.
+type mockNoActorState struct{}
+
+func (s *mockNoActorState) Actions(p int) interface{} {
+	return nil
+}
+
+func (s *mockNoActorState) View(p int) interface{} {
+	return nil
+}
+
+func (s *mockNoActorState) Finished() bool {
+	return false
+}
+
+func (s *mockNoActorState) Result() []int {
+	return []int{ResultTie}
+}
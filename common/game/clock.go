@@ -0,0 +1,227 @@
+package game
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Sent as a client error when a player has used up its entire time bank.
+var ErrFlagged = errors.New("Client ran out of time.")
+
+// Time controls work like a chess clock. Each player gets a bank of time for
+// the whole game, which is refilled by an increment after every move. A player
+// can also be limited to a maximum time per move.
+type TimeControl struct {
+	// The total time a player can think during the game. If it is zero, then
+	// the bank is unlimited and only the per-move limit applies.
+	Bank time.Duration
+	// Time added to the bank after every move.
+	Increment time.Duration
+	// The maximum time a player can take for a single move. If it is zero, then
+	// a player can use its entire bank on one move.
+	Move time.Duration
+}
+
+// Build time controls that give every move the same fixed timeout.
+func FixedTimeControl(timeout time.Duration) TimeControl {
+	return TimeControl{Move: timeout}
+}
+
+// The state of a player's clock sent to the player with every message so it
+// can budget its time. All times are in milliseconds.
+type ClockStatus struct {
+	Remaining int64 `json:"remaining"`
+	Increment int64 `json:"increment"`
+	Move      int64 `json:"move,omitempty"`
+}
+
+// Return the time controls in the same form as a clock status, as if the game
+// had not started yet.
+func (t TimeControl) Status() ClockStatus {
+	return ClockStatus{
+		int64(t.Bank / time.Millisecond),
+		int64(t.Increment / time.Millisecond),
+		int64(t.Move / time.Millisecond),
+	}
+}
+
+// A clock tracks how much time a single player has left.
+type Clock struct {
+	control   TimeControl
+	remaining time.Duration
+	allowed   time.Duration
+	started   time.Time
+	flagged   bool
+	mutex     sync.Mutex
+}
+
+func NewClock(control TimeControl) *Clock {
+	return &Clock{control: control, remaining: control.Bank}
+}
+
+// Start the clock for a move and return how long the player has to make it.
+// A player who has been flagged has no time left to move at all.
+func (c *Clock) Start() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.flagged {
+		c.allowed = 0
+	} else if c.control.Bank == 0 {
+		c.allowed = c.control.Move
+	} else if c.control.Move > 0 && c.control.Move < c.remaining {
+		c.allowed = c.control.Move
+	} else {
+		c.allowed = c.remaining
+	}
+	c.started = time.Now()
+
+	return c.allowed
+}
+
+// Stop the clock after a move, deduct the time the player took from its bank
+// and add the increment. Returns ErrFlagged the first time the player runs out
+// of time.
+func (c *Clock) Stop() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.control.Bank == 0 || c.flagged {
+		return nil
+	}
+
+	elapsed := time.Since(c.started)
+	if elapsed > c.allowed {
+		// the watchdog may fire slightly late, never charge more than allowed
+		elapsed = c.allowed
+	}
+	c.remaining -= elapsed
+	if c.remaining <= 0 {
+		c.remaining = 0
+		c.flagged = true
+		return ErrFlagged
+	}
+	c.remaining += c.control.Increment
+
+	return nil
+}
+
+// Return the time left in the player's bank.
+func (c *Clock) Remaining() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.remaining
+}
+
+// Return the status of the clock to send to the player, or nil if there is no
+// time bank to report.
+func (c *Clock) Status() *ClockStatus {
+	if c.control.Bank == 0 {
+		return nil
+	}
+
+	return &ClockStatus{
+		int64(c.Remaining() / time.Millisecond),
+		int64(c.control.Increment / time.Millisecond),
+		int64(c.control.Move / time.Millisecond),
+	}
+}
+
+// Time settings that games can embed in their own settings, so that each match
+// can be played with its own time controls. All times are in milliseconds, and
+// zero means the default.
+type TimeSettings struct {
+	// How long to wait for every player to connect.
+	ConnectTimeout int64 `json:"connect_timeout,omitempty"`
+	// The maximum time for a single move.
+	MoveTimeout int64 `json:"move_timeout,omitempty"`
+	// The total time a player can think during the game.
+	Bank int64 `json:"bank,omitempty"`
+	// Time added to the bank after every move.
+	Increment int64 `json:"increment,omitempty"`
+	// The most turns a match can last, or zero for no limit.
+	MaxTurns int `json:"max_turns,omitempty"`
+	// The longest a match can last.
+	MaxDuration int64 `json:"max_duration,omitempty"`
+	// How long a player that disconnects has to reconnect.
+	ReconnectGrace int64 `json:"reconnect_grace,omitempty"`
+	// How long a player has to reply to the hello message.
+	HelloTimeout int64 `json:"hello_timeout,omitempty"`
+}
+
+// Settings with time settings embedded in them.
+type TimedSettings interface {
+	Times() *TimeSettings
+}
+
+func (t *TimeSettings) Times() *TimeSettings {
+	return t
+}
+
+func (t *TimeSettings) check() error {
+	if t.ConnectTimeout < 0 || t.MoveTimeout < 0 || t.Bank < 0 || t.Increment < 0 ||
+		t.ReconnectGrace < 0 || t.HelloTimeout < 0 {
+		return errors.New("Times cannot be negative.")
+	}
+	if t.MaxTurns < 0 || t.MaxDuration < 0 {
+		return errors.New("Limits cannot be negative.")
+	}
+	if t.Increment > 0 && t.Bank == 0 {
+		return errors.New("An increment needs a bank to add to.")
+	}
+	return nil
+}
+
+// Return how long to wait for every player to connect.
+func (t *TimeSettings) Connect() time.Duration {
+	if t.ConnectTimeout == 0 {
+		return ConnTimeout
+	}
+	return time.Duration(t.ConnectTimeout) * time.Millisecond
+}
+
+// Return how long a player that disconnects has to reconnect before it is gone
+// for good.
+func (t *TimeSettings) Grace() time.Duration {
+	if t.ReconnectGrace == 0 {
+		return ReconnectTimeout
+	}
+	return time.Duration(t.ReconnectGrace) * time.Millisecond
+}
+
+// Return how long a player has to reply to the hello message.
+func (t *TimeSettings) Hello() time.Duration {
+	if t.HelloTimeout == 0 {
+		return HandshakeTimeout
+	}
+	return time.Duration(t.HelloTimeout) * time.Millisecond
+}
+
+// Return the time controls of a match. Without a bank or a move timeout every
+// move gets the default MoveTimeout.
+func (t *TimeSettings) TimeControl() TimeControl {
+	control := TimeControl{
+		Bank:      time.Duration(t.Bank) * time.Millisecond,
+		Increment: time.Duration(t.Increment) * time.Millisecond,
+		Move:      time.Duration(t.MoveTimeout) * time.Millisecond,
+	}
+	if control.Bank == 0 && control.Move == 0 {
+		control.Move = MoveTimeout
+	}
+	return control
+}
+
+// Return the limits on the length of a match. Without a maximum duration a
+// match can last MatchTimeout.
+func (t *TimeSettings) Limits() Limits {
+	limits := Limits{
+		Turns:    t.MaxTurns,
+		Duration: time.Duration(t.MaxDuration) * time.Millisecond,
+	}
+	if limits.Duration == 0 {
+		limits.Duration = MatchTimeout
+	}
+	return limits
+}
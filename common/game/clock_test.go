@@ -0,0 +1,204 @@
+package game
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFixedClock(t *testing.T) {
+	clock := NewClock(FixedTimeControl(time.Second))
+
+	if clock.Start() != time.Second {
+		t.Error("Clock does not allow 1 second per move")
+	}
+	if err := clock.Stop(); err != nil {
+		t.Error(err)
+	}
+	if clock.Status() != nil {
+		t.Error("Clock without a bank should not report a status")
+	}
+}
+
+func TestTimeSettings(t *testing.T) {
+	times := &TimeSettings{}
+	if times.Connect() != ConnTimeout {
+		t.Error("Connect timeout is not the default")
+	}
+	if times.TimeControl() != FixedTimeControl(MoveTimeout) {
+		t.Error("Time controls are not the default")
+	}
+	if times.Grace() != ReconnectTimeout {
+		t.Error("Reconnect grace is not the default")
+	}
+	if times.Hello() != HandshakeTimeout {
+		t.Error("Hello timeout is not the default")
+	}
+
+	times = &TimeSettings{
+		ConnectTimeout: 500, Bank: 3000, Increment: 100, ReconnectGrace: 2000,
+		HelloTimeout: 1000,
+	}
+	if times.Connect() != 500*time.Millisecond {
+		t.Error("Connect timeout is not 500ms")
+	}
+	if times.Grace() != 2*time.Second {
+		t.Error("Reconnect grace is not 2s")
+	}
+	if times.Hello() != time.Second {
+		t.Error("Hello timeout is not 1s")
+	}
+	control := times.TimeControl()
+	if control.Bank != 3*time.Second || control.Move != 0 {
+		t.Error("Time controls without a move timeout did not use the bank")
+	}
+	if err := times.check(); err != nil {
+		t.Error(err)
+	}
+	times.Bank = 0
+	if times.check() == nil {
+		t.Error("Increment without a bank was accepted")
+	}
+}
+
+func TestTimeSettingsLimits(t *testing.T) {
+	times := &TimeSettings{}
+	if times.Limits() != (Limits{Duration: MatchTimeout}) {
+		t.Error("Limits are not the default")
+	}
+
+	times = &TimeSettings{MaxTurns: 100, MaxDuration: 60000}
+	if times.Limits() != (Limits{Turns: 100, Duration: time.Minute}) {
+		t.Error("Limits are not 100 turns and a minute")
+	}
+	times.MaxTurns = -1
+	if times.check() == nil {
+		t.Error("Negative turn limit was accepted")
+	}
+}
+
+func TestClockBank(t *testing.T) {
+	clock := NewClock(TimeControl{
+		Bank:      50 * time.Millisecond,
+		Increment: 10 * time.Millisecond,
+	})
+
+	if clock.Start() != 50*time.Millisecond {
+		t.Error("Clock does not allow the entire bank")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := clock.Stop(); err != nil {
+		t.Error(err)
+	}
+
+	remaining := clock.Remaining()
+	if remaining > 40*time.Millisecond || remaining < 30*time.Millisecond {
+		t.Error("Clock did not deduct think time and add the increment")
+	}
+
+	status := clock.Status()
+	if status == nil || status.Remaining != int64(remaining/time.Millisecond) {
+		t.Error("Clock status does not report the remaining time")
+	}
+	if status.Increment != 10 {
+		t.Error("Clock status does not report the increment")
+	}
+}
+
+func TestClockMoveCap(t *testing.T) {
+	clock := NewClock(TimeControl{
+		Bank: time.Second,
+		Move: 10 * time.Millisecond,
+	})
+
+	if clock.Start() != 10*time.Millisecond {
+		t.Error("Clock does not cap the time per move")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := clock.Stop(); err != nil {
+		t.Error("Clock flagged a player for exceeding the move cap")
+	}
+	if clock.Remaining() != 990*time.Millisecond {
+		t.Error("Clock deducted more than the move cap")
+	}
+}
+
+func TestClockFlagged(t *testing.T) {
+	clock := NewClock(TimeControl{Bank: 10 * time.Millisecond})
+
+	clock.Start()
+	time.Sleep(20 * time.Millisecond)
+	if err := clock.Stop(); err != ErrFlagged {
+		t.Error("Clock did not flag a player out of time")
+	}
+	if clock.Remaining() != 0 {
+		t.Error("Clock has time remaining after being flagged")
+	}
+
+	if clock.Start() != 0 {
+		t.Error("Clock allows a flagged player to think")
+	}
+	if err := clock.Stop(); err != nil {
+		t.Error("Clock flagged a player more than once")
+	}
+}
+
+func TestSynchronizedTimeBank(t *testing.T) {
+	state := &mockState{[]int{0, 0}}
+	turnChan := make(chan Turn)
+	errChan := make(chan error)
+	stateMan := NewSynchronizedStateManager(state, time.Second)
+	stateMan.SetTimeControl(TimeControl{Bank: 30 * time.Millisecond})
+
+	clients := []GameClient{
+		stateMan.NewClient("1", nil),
+		stateMan.NewClient("2", nil),
+	}
+
+	wg := stateMan.Play(context.Background(), clients, turnChan, errChan)
+
+	flagged := false
+	moves := 0
+	go func() {
+		for {
+			select {
+			case msg := <-clients[0].Send():
+				if msg.Type == MessageWarning && msg.Code == ErrorTimeout {
+					// player 1 is warned when it takes too long
+					continue
+				}
+				if msg.Clock == nil {
+					t.Error("Player 1 was not sent its clock")
+				}
+				// player 1 thinks too long and runs out of time on the second move
+				moves++
+				if moves == 1 {
+					time.Sleep(20 * time.Millisecond)
+					clients[0].Receive() <- ClientMessage{StringAction("1")}
+				}
+			case <-clients[1].Send():
+				clients[1].Receive() <- ClientMessage{StringAction("3")}
+			case err := <-errChan:
+				if e, ok := err.(ClientError); ok && e.err == ErrFlagged {
+					if e.client != clients[0] {
+						t.Error("The wrong player was flagged")
+					}
+					flagged = true
+				}
+			case <-turnChan:
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	if !flagged {
+		t.Error("Player 1 was not flagged")
+	}
+	if state.Players[0] != 1 {
+		t.Error("Player 1 moved after being flagged")
+	}
+	if state.Players[1] != 12 {
+		t.Error("Player 2 score is not 12")
+	}
+}
@@ -0,0 +1,612 @@
+package game
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/net/websocket"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+// The codecs clients can choose to speak, by asking for one of them as the
+// websocket subprotocol when they connect. Clients that ask for none of them
+// speak JSON.
+const (
+	CodecJSON        = "json"
+	CodecMessagePack = "msgpack"
+	CodecCBOR        = "cbor"
+)
+
+// A codec encodes the messages exchanged with a client over a websocket.
+// Server messages are encoded with the json tags of their fields, so game
+// states do not need to know how they are sent. Game values that JSON encodes
+// in a way of their own are encoded through their JSON form, so that clients
+// get the same fields whichever codec they speak. Messages from clients are
+// decoded with the json tags of their fields too, and actions reach the game
+// as JSON no matter how they were sent. websocket.JSON is the JSON codec.
+type Codec interface {
+	Send(conn *websocket.Conn, v interface{}) error
+	Receive(conn *websocket.Conn, v interface{}) error
+}
+
+// The MessagePack codec, which is sent in binary frames.
+var MessagePack Codec = websocket.Codec{
+	Marshal:   marshalMessagePack,
+	Unmarshal: unmarshalMessagePack,
+}
+
+// The CBOR codec, which is sent in binary frames.
+var CBOR Codec = websocket.Codec{
+	Marshal:   marshalCBOR,
+	Unmarshal: unmarshalCBOR,
+}
+
+var codecs = map[string]Codec{
+	CodecJSON:        websocket.JSON,
+	CodecMessagePack: MessagePack,
+	CodecCBOR:        CBOR,
+}
+
+// Find a codec by the name clients ask for it with.
+func LookupCodec(name string) (Codec, error) {
+	codec, ok := codecs[name]
+	if !ok {
+		return nil, errors.New("Codec " + name + " is not supported.")
+	}
+	return codec, nil
+}
+
+// Return the codec that was negotiated when a connection was made, or JSON if
+// there was none.
+func ConnCodec(conn *websocket.Conn) Codec {
+	return codecs[connCodec(conn)]
+}
+
+// Return the name of the codec that was negotiated when a connection was made.
+func connCodec(conn *websocket.Conn) string {
+	if config := conn.Config(); config != nil && len(config.Protocol) == 1 {
+		if _, ok := codecs[config.Protocol[0]]; ok {
+			return config.Protocol[0]
+		}
+	}
+	return CodecJSON
+}
+
+// Return the name of the codec a client speaks. Clients without a websocket
+// speak JSON.
+func clientCodec(c GameClient) string {
+	if _, ok := c.(Listener); ok {
+		return CodecJSON
+	}
+	if conn := c.Conn(); conn != nil {
+		return connCodec(conn)
+	}
+	return CodecJSON
+}
+
+// Check the origin of a websocket handshake like websocket.Handler does, and
+// choose the first codec the client asks for that the server supports. No
+// subprotocol is chosen if the client asks for none that are supported, and
+// the client speaks JSON.
+func negotiate(config *websocket.Config, req *http.Request) error {
+	origin, err := websocket.Origin(config, req)
+	if err != nil {
+		return err
+	}
+	if origin == nil {
+		return errors.New("Null origin.")
+	}
+	config.Origin = origin
+
+	protocols := config.Protocol
+	config.Protocol = nil
+	for _, p := range protocols {
+		if _, ok := codecs[p]; ok {
+			config.Protocol = []string{p}
+			break
+		}
+	}
+
+	return nil
+}
+
+func init() {
+	// raw JSON is sent as the values it holds, and the messages send the
+	// values games put in them through their JSON where needed
+	msgpack.Register(
+		json.RawMessage(nil), encodeRawMessagePack, decodeRawMessagePack,
+	)
+	msgpack.Register(ServerMessage{}, encodeMessageMessagePack, nil)
+	msgpack.Register(HelloMessage{}, encodeMessageMessagePack, nil)
+}
+
+func marshalMessagePack(v interface{}) ([]byte, byte, error) {
+	data, err := encodeMessagePack(v)
+	return data, websocket.BinaryFrame, err
+}
+
+// Encode a value with the json tags of its fields.
+func encodeMessagePack(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	err := enc.Encode(v)
+	return buf.Bytes(), err
+}
+
+func unmarshalMessagePack(data []byte, payloadType byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func encodeRawMessagePack(enc *msgpack.Encoder, v reflect.Value) error {
+	return transcodeJSON(v.Bytes(), messagePackWriter{enc})
+}
+
+// Decode a value into raw JSON, so that actions reach the game as JSON.
+func decodeRawMessagePack(dec *msgpack.Decoder, v reflect.Value) error {
+	doc, err := dec.DecodeInterface()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	v.SetBytes(data)
+	return nil
+}
+
+func encodeMessageMessagePack(enc *msgpack.Encoder, v reflect.Value) error {
+	return enc.Encode(envelope(v.Interface()))
+}
+
+// Values that JSON encodes in a way of their own are encoded through their
+// JSON, and raw JSON is sent as the values it holds.
+var cborEncoder, _ = cbor.EncOptions{
+	JSONMarshalerTranscoder: transcoder(func(w io.Writer, r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return transcodeJSON(data, &cborWriter{w: w})
+	}),
+}.EncMode()
+
+// Documents are decoded with string keys, like JSON objects.
+var cborDocuments, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]interface{}{}),
+}.DecMode()
+
+// Values are decoded into raw JSON, so that actions reach the game as JSON.
+var cborDecoder, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]interface{}{}),
+	JSONUnmarshalerTranscoder: transcoder(func(w io.Writer, r io.Reader) error {
+		var doc interface{}
+		err := cborDocuments.NewDecoder(r).Decode(&doc)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(doc)
+	}),
+}.DecMode()
+
+// A transcoding function for the CBOR codec.
+type transcoder func(w io.Writer, r io.Reader) error
+
+func (t transcoder) Transcode(w io.Writer, r io.Reader) error {
+	return t(w, r)
+}
+
+func marshalCBOR(v interface{}) ([]byte, byte, error) {
+	data, err := cborEncoder.Marshal(v)
+	return data, websocket.BinaryFrame, err
+}
+
+func unmarshalCBOR(data []byte, payloadType byte, v interface{}) error {
+	return cborDecoder.Unmarshal(data, v)
+}
+
+func (m ServerMessage) MarshalCBOR() ([]byte, error) {
+	return cborEncoder.Marshal(envelope(m))
+}
+
+func (m HelloMessage) MarshalCBOR() ([]byte, error) {
+	return cborEncoder.Marshal(envelope(m))
+}
+
+// Messages as types without encoders of their own, which the binary codecs
+// encode by their json tags.
+type serverEnvelope ServerMessage
+type helloEnvelope HelloMessage
+
+// Return a copy of a message that the binary codecs encode with the same
+// fields as JSON. The values games put in the message are sent through their
+// JSON if encoding them by their json tags would give different fields.
+func envelope(v interface{}) interface{} {
+	switch m := v.(type) {
+	case ServerMessage:
+		e := serverEnvelope(m)
+		e.Actions = asJSON(m.Actions)
+		e.State = asJSON(m.State)
+		return &e
+	case HelloMessage:
+		e := helloEnvelope(m)
+		e.Settings = asJSON(m.Settings)
+		return &e
+	}
+	return v
+}
+
+// A value that the binary codecs encode through its JSON.
+type viaJSON struct {
+	v interface{}
+}
+
+// Wrap a value so that it is sent through its JSON, if its json tags would
+// give different fields.
+func asJSON(v interface{}) interface{} {
+	if v == nil || !jsonOnly(reflect.TypeOf(v)) {
+		return v
+	}
+	return &viaJSON{v}
+}
+
+func (j viaJSON) EncodeMsgpack(enc *msgpack.Encoder) error {
+	data, err := json.Marshal(j.v)
+	if err != nil {
+		return err
+	}
+	return transcodeJSON(data, messagePackWriter{enc})
+}
+
+func (j viaJSON) MarshalCBOR() ([]byte, error) {
+	data, err := json.Marshal(j.v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = transcodeJSON(data, &cborWriter{w: &buf})
+	return buf.Bytes(), err
+}
+
+// Writes the tokens of a JSON document in a binary codec. Arrays and objects
+// are written with the number of values and of keys and values they hold.
+type tokenWriter interface {
+	array(n int) error
+	object(n int) error
+	token(t json.Token) error
+}
+
+// Transcode a JSON document into a binary codec token by token, without
+// decoding it into values first. Numbers are integers where they are whole,
+// and floats otherwise, like they would be encoded from the value.
+func transcodeJSON(data []byte, w tokenWriter) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	tokens := []json.Token{}
+	// the number of tokens directly inside every array and object, by the
+	// index of the token that opens it
+	lengths := map[int]int{}
+	open := []int{}
+	for {
+		t, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if d, ok := t.(json.Delim); ok && (d == ']' || d == '}') {
+			open = open[:len(open)-1]
+		} else {
+			if len(open) > 0 {
+				lengths[open[len(open)-1]]++
+			}
+			if ok {
+				open = append(open, len(tokens))
+			}
+		}
+		tokens = append(tokens, t)
+	}
+
+	for i, t := range tokens {
+		var err error
+		switch t {
+		case json.Delim('['):
+			err = w.array(lengths[i])
+		case json.Delim('{'):
+			err = w.object(lengths[i] / 2)
+		case json.Delim(']'), json.Delim('}'):
+		default:
+			err = w.token(t)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type messagePackWriter struct {
+	enc *msgpack.Encoder
+}
+
+func (w messagePackWriter) array(n int) error {
+	return w.enc.EncodeArrayLen(n)
+}
+
+func (w messagePackWriter) object(n int) error {
+	return w.enc.EncodeMapLen(n)
+}
+
+func (w messagePackWriter) token(t json.Token) error {
+	switch v := t.(type) {
+	case string:
+		return w.enc.EncodeString(v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return w.enc.EncodeInt(i)
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		return w.enc.EncodeFloat64(f)
+	case bool:
+		return w.enc.EncodeBool(v)
+	}
+	return w.enc.EncodeNil()
+}
+
+// Writes the CBOR data items that cbor.Marshal encodes the same values as.
+type cborWriter struct {
+	w   io.Writer
+	buf [9]byte
+}
+
+// Write the head of a data item of a major type with its argument.
+func (w *cborWriter) head(major byte, n uint64) error {
+	b := w.buf[:]
+	switch {
+	case n < 24:
+		b[0] = major<<5 | byte(n)
+		b = b[:1]
+	case n <= math.MaxUint8:
+		b[0], b[1] = major<<5|24, byte(n)
+		b = b[:2]
+	case n <= math.MaxUint16:
+		b[0] = major<<5 | 25
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		b = b[:3]
+	case n <= math.MaxUint32:
+		b[0] = major<<5 | 26
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		b = b[:5]
+	default:
+		b[0] = major<<5 | 27
+		binary.BigEndian.PutUint64(b[1:], n)
+	}
+	_, err := w.w.Write(b)
+	return err
+}
+
+func (w *cborWriter) array(n int) error {
+	return w.head(4, uint64(n))
+}
+
+func (w *cborWriter) object(n int) error {
+	return w.head(5, uint64(n))
+}
+
+func (w *cborWriter) token(t json.Token) error {
+	switch v := t.(type) {
+	case string:
+		err := w.head(3, uint64(len(v)))
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w.w, v)
+		return err
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			if i < 0 {
+				return w.head(1, uint64(-1-i))
+			}
+			return w.head(0, uint64(i))
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		w.buf[0] = 0xfb
+		binary.BigEndian.PutUint64(w.buf[1:], math.Float64bits(f))
+		_, err = w.w.Write(w.buf[:])
+		return err
+	case bool:
+		w.buf[0] = 0xf4
+		if v {
+			w.buf[0] = 0xf5
+		}
+		_, err := w.w.Write(w.buf[:1])
+		return err
+	}
+	w.buf[0] = 0xf6
+	_, err := w.w.Write(w.buf[:1])
+	return err
+}
+
+// Encode and decode values that are not messages in every codec, so that
+// values can be encoded before they are sent.
+var encoders = map[string]func(v interface{}) ([]byte, error){
+	CodecJSON: json.Marshal,
+	CodecMessagePack: func(v interface{}) ([]byte, error) {
+		return encodeMessagePack(asJSON(v))
+	},
+	CodecCBOR: func(v interface{}) ([]byte, error) {
+		return cborEncoder.Marshal(asJSON(v))
+	},
+}
+
+var decoders = map[string]func(data []byte) (interface{}, error){
+	CodecJSON: func(data []byte) (interface{}, error) {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var doc interface{}
+		err := dec.Decode(&doc)
+		return numbers(doc), err
+	},
+	CodecMessagePack: func(data []byte) (interface{}, error) {
+		var doc interface{}
+		err := msgpack.Unmarshal(data, &doc)
+		return doc, err
+	},
+	CodecCBOR: func(data []byte) (interface{}, error) {
+		var doc interface{}
+		err := cborDocuments.Unmarshal(data, &doc)
+		return doc, err
+	},
+}
+
+// A value that was encoded ahead of time in the codec of the client it is sent
+// to, so that it can be sent while the value keeps changing, e.g., the view of
+// a game state that the next turn changes. Messages are encoded as if the
+// value was in its place. An error encoding the value is returned when the
+// message is sent.
+type frozen struct {
+	codec string
+	data  []byte
+	err   error
+}
+
+var frozenType = reflect.TypeOf(frozen{})
+
+// Encode a value right away in the codec a client speaks. Nil stays nil, so
+// that it is still left out of messages.
+func freeze(c GameClient, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return freezeAs(clientCodec(c), v)
+}
+
+// Encode a value right away in a codec.
+func freezeAs(codec string, v interface{}) frozen {
+	data, err := encoders[codec](v)
+	return frozen{codec, data, err}
+}
+
+// Return the value encoded in a codec. A client that reconnected may speak
+// another codec than the value was frozen in, and then the value is decoded
+// and encoded again.
+func (f frozen) encode(codec string) ([]byte, error) {
+	if f.err != nil || f.codec == codec {
+		return f.data, f.err
+	}
+	doc, err := decoders[f.codec](f.data)
+	if err != nil {
+		return nil, err
+	}
+	return encoders[codec](doc)
+}
+
+func (f frozen) MarshalJSON() ([]byte, error) {
+	return f.encode(CodecJSON)
+}
+
+func (f frozen) EncodeMsgpack(enc *msgpack.Encoder) error {
+	data, err := f.encode(CodecMessagePack)
+	if err != nil {
+		return err
+	}
+	return msgpack.RawMessage(data).EncodeMsgpack(enc)
+}
+
+func (f frozen) MarshalCBOR() ([]byte, error) {
+	return f.encode(CodecCBOR)
+}
+
+// Turn the numbers of a JSON document into integers where they are whole, and
+// floats otherwise, like they would be encoded from the value.
+func numbers(doc interface{}) interface{} {
+	switch d := doc.(type) {
+	case json.Number:
+		if i, err := d.Int64(); err == nil {
+			return i
+		}
+		f, _ := d.Float64()
+		return f
+	case map[string]interface{}:
+		for k, v := range d {
+			d[k] = numbers(v)
+		}
+	case []interface{}:
+		for i, v := range d {
+			d[i] = numbers(v)
+		}
+	}
+	return doc
+}
+
+var jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+var rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+var textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// Whether types are encoded differently as JSON than by their json tags.
+var jsonOnlyTypes sync.Map
+
+// Report whether values of a type may be encoded differently as JSON than by
+// their json tags, because something in them has its own JSON encoding, is a
+// map that JSON gives string keys, or could be anything.
+func jsonOnly(t reflect.Type) bool {
+	if only, ok := jsonOnlyTypes.Load(t); ok {
+		return only.(bool)
+	}
+	only := jsonOnlyType(t, map[reflect.Type]bool{})
+	jsonOnlyTypes.Store(t, only)
+	return only
+}
+
+func jsonOnlyType(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		// a recursive type is only as special as the rest of it
+		return false
+	}
+	seen[t] = true
+
+	if t == frozenType || t == rawMessageType {
+		// frozen values are already encoded in the right form, and raw JSON is
+		// sent as the values it holds
+		return false
+	}
+	for _, m := range []reflect.Type{jsonMarshaler, textMarshaler} {
+		if t.Implements(m) || reflect.PtrTo(t).Implements(m) {
+			return true
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return jsonOnlyType(t.Elem(), seen)
+	case reflect.Map:
+		return t.Key().Kind() != reflect.String || jsonOnlyType(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if jsonOnlyType(t.Field(i).Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,282 @@
+package game
+
+import (
+	"encoding/json"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/net/websocket"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Encode and decode messages the way a client speaking the codec would.
+func clientMarshal(codec string, v interface{}) ([]byte, error) {
+	switch codec {
+	case CodecMessagePack:
+		return msgpack.Marshal(v)
+	case CodecCBOR:
+		return cbor.Marshal(v)
+	}
+	return json.Marshal(v)
+}
+
+func clientUnmarshal(codec string, data []byte, v interface{}) error {
+	switch codec {
+	case CodecMessagePack:
+		return msgpack.Unmarshal(data, v)
+	case CodecCBOR:
+		return cborDecoder.Unmarshal(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+func TestNegotiateCodec(t *testing.T) {
+	received := make(chan ClientMessage, 1)
+	url, ts := setupTestServer(websocket.Server{
+		Handshake: negotiate,
+		Handler: func(conn *websocket.Conn) {
+			codec := ConnCodec(conn)
+			codec.Send(conn, &ServerMessage{
+				Type:  MessageState,
+				Turn:  3,
+				State: map[string]int{"cells": 2},
+			})
+			var msg ClientMessage
+			codec.Receive(conn, &msg)
+			received <- msg
+		},
+	})
+	defer ts.Close()
+
+	cases := []struct {
+		offered []string
+		codec   string
+	}{
+		{nil, CodecJSON},
+		{[]string{"bogus"}, CodecJSON},
+		{[]string{"bogus", CodecCBOR}, CodecCBOR},
+		{[]string{CodecMessagePack, CodecCBOR}, CodecMessagePack},
+		{[]string{CodecJSON}, CodecJSON},
+	}
+	for _, c := range cases {
+		config, err := websocket.NewConfig(url, "http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Protocol = c.offered
+		conn, err := websocket.DialConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chosen := CodecJSON
+		if c.codec != CodecJSON {
+			if p := conn.Config().Protocol; len(p) != 1 || p[0] != c.codec {
+				t.Error("Server did not choose ", c.codec, " from ", c.offered)
+			}
+			chosen = conn.Config().Protocol[0]
+		}
+
+		var data []byte
+		err = websocket.Message.Receive(conn, &data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := map[string]interface{}{}
+		err = clientUnmarshal(chosen, data, &msg)
+		if err != nil {
+			t.Error(err)
+		}
+		if msg["type"] != MessageState || msg["state"] == nil {
+			t.Error("Message was not encoded with its json tags in ", chosen)
+		}
+
+		data, _ = clientMarshal(chosen, map[string]interface{}{
+			"action": map[string]interface{}{"dir": "north", "speed": 2},
+		})
+		websocket.Message.Send(conn, data)
+		select {
+		case msg := <-received:
+			var action struct {
+				Dir   string `json:"dir"`
+				Speed int    `json:"speed"`
+			}
+			err := json.Unmarshal(msg.Action, &action)
+			if err != nil || action.Dir != "north" || action.Speed != 2 {
+				t.Error("Action did not reach the game as JSON from ", chosen)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Server did not receive the action")
+		}
+		conn.Close()
+	}
+}
+
+// A game state that is only sent through its JSON.
+type mockCells map[int]string
+
+func (c mockCells) MarshalJSON() ([]byte, error) {
+	cells := map[string]string{}
+	for k, v := range c {
+		cells["cell"+strconv.Itoa(k)] = v
+	}
+	return json.Marshal(cells)
+}
+
+func TestCodecFields(t *testing.T) {
+	states := []interface{}{
+		Adjudicate(
+			&mockAdjudicatorState{mockState{[]int{4, 2}}}, 2, AdjudicatedTurns,
+		),
+		json.RawMessage(`{"cells":[1,2.5],"name":"grid"}`),
+		struct {
+			Cells mockCells   `json:"cells"`
+			Owner map[int]int `json:"owner"`
+			Any   interface{} `json:"any"`
+			Plain []mockState `json:"plain"`
+		}{mockCells{1: "x"}, map[int]int{3: 1}, mockCells{2: "o"}, nil},
+	}
+	marshal := map[string]func(interface{}) ([]byte, byte, error){
+		CodecMessagePack: marshalMessagePack,
+		CodecCBOR:        marshalCBOR,
+	}
+
+	for _, state := range states {
+		msg := &ServerMessage{
+			Type:    MessageState,
+			Turn:    3,
+			Actions: []json.RawMessage{StringAction("north")},
+			State:   state,
+		}
+		data, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want interface{}
+		json.Unmarshal(data, &want)
+
+		for codec, m := range marshal {
+			data, _, err := m(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got interface{}
+			err = clientUnmarshal(codec, data, &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// compare the documents as JSON sees them, so numbers match
+			data, err = json.Marshal(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = nil
+			json.Unmarshal(data, &got)
+			if !reflect.DeepEqual(got, want) {
+				t.Error(codec, " sent ", got, " instead of ", want)
+			}
+		}
+	}
+}
+
+func TestLookupCodec(t *testing.T) {
+	for _, name := range []string{CodecJSON, CodecMessagePack, CodecCBOR} {
+		if _, err := LookupCodec(name); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := LookupCodec("xml"); err == nil {
+		t.Error("Unknown codec was found")
+	}
+}
+
+func TestTranscodeJSON(t *testing.T) {
+	docs := []string{
+		`{"cells":{"1":{"2":0}},"players":[{"x":-3,"y":300000}],"w":64}`,
+		`[1.5,-2.25e3,18446744073709551616,-129,65536,4294967296]`,
+		`{"name":"a string that is longer than twenty-three bytes","empty":""}`,
+		`[[],{},[[]],null,true,false]`,
+		`"été"`,
+	}
+	for _, doc := range docs {
+		var want interface{}
+		json.Unmarshal([]byte(doc), &want)
+
+		for _, codec := range []string{CodecMessagePack, CodecCBOR} {
+			data, err := encoders[codec](json.RawMessage(doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got interface{}
+			err = clientUnmarshal(codec, data, &got)
+			if err != nil {
+				t.Fatal(codec, " could not decode ", doc, ": ", err)
+			}
+			data, err = json.Marshal(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = nil
+			json.Unmarshal(data, &got)
+			if !reflect.DeepEqual(got, want) {
+				t.Error(codec, " sent ", got, " instead of ", doc)
+			}
+		}
+	}
+}
+
+// A state as large as a Tron game on the largest board that is half full.
+type benchmarkState struct {
+	Cells      map[string]map[string]int `json:"cells"`
+	Players    []struct{ X, Y int }      `json:"players"`
+	Directions []string
+	Width      int `json:"w"`
+	Height     int `json:"h"`
+}
+
+func newBenchmarkState() *benchmarkState {
+	s := &benchmarkState{
+		Cells:      map[string]map[string]int{},
+		Players:    make([]struct{ X, Y int }, 2),
+		Directions: []string{"north", "south"},
+		Width:      64,
+		Height:     64,
+	}
+	for x := 0; x < s.Width; x++ {
+		column := map[string]int{}
+		for y := 0; y < s.Height; y += 2 {
+			column[strconv.Itoa(y)] = (x + y) % 2
+		}
+		s.Cells[strconv.Itoa(x)] = column
+	}
+	return s
+}
+
+// Encode state messages the way they are sent, with the state encoded ahead
+// of time in the codec of the client.
+func BenchmarkCodecs(b *testing.B) {
+	marshal := map[string]func(interface{}) ([]byte, byte, error){
+		CodecJSON:        websocket.JSON.Marshal,
+		CodecMessagePack: marshalMessagePack,
+		CodecCBOR:        marshalCBOR,
+	}
+	state := newBenchmarkState()
+	actions := []string{"north", "south", "east", "west"}
+
+	for _, codec := range []string{CodecJSON, CodecMessagePack, CodecCBOR} {
+		b.Run(codec, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _, err := marshal[codec](&ServerMessage{
+					Type:    MessageState,
+					Turn:    i,
+					Actions: freezeAs(codec, actions),
+					State:   freezeAs(codec, state),
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
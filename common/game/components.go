@@ -1,6 +1,7 @@
 package game
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/crestonbunch/botbox/services/sandbox"
@@ -8,12 +9,23 @@ import (
 	"log"
 	"os"
 	"path"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const ConnTimeout = 10 * time.Second
 const MoveTimeout = 10 * time.Second
+const ReconnectTimeout = 10 * time.Second
+const HandshakeTimeout = 5 * time.Second
+
+// The largest message a client can send in bytes.
+const MaxMessageSize = 64 << 10
+
+// The most messages a client can send between two state messages. Clients only
+// need to send one action per turn, so anything more is flooding the server.
+const MessageBudget = 10
 
 type GameClient interface {
 	// Get a unique identifier for this client.
@@ -23,6 +35,14 @@ type GameClient interface {
 	// Return a watchdog for this client with a timeout on how long it can take
 	// to make a move. Keeps clients from blocking forever.
 	Watchdog() *Watchdog
+	// Return the clock that tracks how much time this client has left to think
+	// according to the time controls of the game.
+	Clock() *Clock
+	// Replace the websocket connection of a client that has reconnected.
+	Reconnect(*websocket.Conn)
+	// Receives a value every time the client reconnects, so that anything the
+	// client missed can be sent again.
+	Reconnected() chan bool
 
 	// Send and receive channels for communicating with the client
 	Send() chan ServerMessage
@@ -33,12 +53,11 @@ type GameClient interface {
 type ConnectionManager interface {
 	// Create a websocket handler that will send connections along the given
 	// channel. This channel can be given to a listener which will do something
-	// with the connections. E.g., a client manager. The last channel is
-	// the exit channel, which should terminate the handler when it recieves a
-	// value.
-	Handler(chan *websocket.Conn, chan error) websocket.Handler
+	// with the connections. E.g., a client manager. Every handler returns once
+	// the context is done.
+	Handler(context.Context, chan *websocket.Conn) websocket.Server
 
-	// Close all of the active connections.
+	// Close all of the active connections. Must not block.
 	Close()
 }
 
@@ -47,13 +66,22 @@ type ClientManager interface {
 	// Listen to a channel that receives websocket connections and authenticate
 	// connections. If they are valid, allow them to remain connected other wise
 	// close them immediately. Return a waitgroup that finished when all clients
-	// are connected or a timeout occurs.
-	Register(chan *websocket.Conn) *sync.WaitGroup
+	// are connected, a timeout occurs or the context is done. Stop listening
+	// for reconnections when the context is done.
+	Register(context.Context, chan *websocket.Conn) *sync.WaitGroup
 	// Return whether or not the client manager has all of the expected clients
 	// connected.
 	Ready() bool
 	// Get a list of the connected clients.
 	Clients() []GameClient
+	// Tell the client manager that a client has disconnected, so that it may
+	// allow the client to reconnect.
+	Disconnected(GameClient)
+	// Tell the client manager that a client broke a rule, so that it closes
+	// the connection of the client and never lets it reconnect.
+	Banned(GameClient)
+	// Receives clients that have reconnected after the game started.
+	Reconnections() chan GameClient
 }
 
 // A manager that handles how actions should be received from clients. I.e.,
@@ -61,53 +89,80 @@ type ClientManager interface {
 type StateManager interface {
 	// Given a list of game clients, spawn a goroutine and play the game by
 	// sending/receiving messages according to how the game should progress.
-	// Return a channel which will receive the game state every time it changes.
-	// Return a waitgroup that finished when the game is over or a timeout
-	// occurs.
-	Play([]GameClient, chan GameState, chan error) *sync.WaitGroup
+	// The turn channel will receive a turn every time the state changes.
+	// Return a waitgroup that finished when the game is over or the context is
+	// done.
+	Play(context.Context, []GameClient, chan Turn, chan error) *sync.WaitGroup
 }
 
 type GameRecorder interface {
-	LogState(GameState) error
+	LogTurn(Turn) error
 	LogResult(GameState) error
 	LogConnection(GameClient) error
 	LogDisconnection(GameClient) error
+	LogReconnection(GameClient) error
+	LogViolation(GameClient, ViolationError) error
 	Close() error
 }
 
 // Start the game components and return a websocket handler that can be used
 // to start or mock and HTTP server and receive requests. Must be given a
 // connection manager, client manager, state manager, and game recorder. The
-// first channel parameter will be passed a value when the game is over, that
-// can be used to stop the HTTP server.
+// returned channel is closed when the game is over and every component has
+// shut down, so that the HTTP server can be stopped. Cancelling the context
+// aborts the game and shuts it down the same way.
 func GameHandler(
-	exitChan chan bool,
+	ctx context.Context,
 	connMan ConnectionManager,
 	clientMan ClientManager,
 	stateMan StateManager,
 	record GameRecorder,
-) websocket.Handler {
+) (websocket.Server, <-chan struct{}) {
 
-	abortChan := make(chan bool)
+	// every component is stopped by cancelling the context once the game is
+	// over
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	abortChan := make(chan bool, 1)
 	errChan := make(chan error)
 	connChan := make(chan *websocket.Conn)
-	stateChan := make(chan GameState)
-	handler := connMan.Handler(connChan, errChan)
+	turnChan := make(chan Turn)
+	handler := connMan.Handler(ctx, connChan)
+
+	// the goroutines listening to clients, which only stop once the
+	// connections are closed
+	var listeners []*sync.WaitGroup
+	var mutex sync.Mutex
+	track := func(wg *sync.WaitGroup) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		listeners = append(listeners, wg)
+	}
+	listen := func(c GameClient) {
+		track(Listen(ctx, c))
+	}
 
+	var playing sync.WaitGroup
+	playing.Add(1)
 	go func() {
+		defer playing.Done()
+
 		log.Println("Waiting for connections.")
 		// Bind the client manager to the connection channel so that each time a
 		// connection is made by the connection manager, the client manager will
 		// inspect it and accept / reject the client and close the connection if
 		// necessary
-		wg := clientMan.Register(connChan)
+		wg := clientMan.Register(ctx, connChan)
 		// wait until all clients are connected or a timeout occurs
 		wg.Wait()
 
 		for _, c := range clientMan.Clients() {
 			// Log clients that successfully connected.
 			record.LogConnection(c)
-			Listen(c)
+			listen(c)
+			// Forward errors from the client so that they are logged no matter
+			// what the state manager is doing.
+			track(forward(ctx, c, errChan))
 		}
 
 		if !clientMan.Ready() {
@@ -120,126 +175,453 @@ func GameHandler(
 		log.Println("All clients connected.")
 		// If all clients are connected, begin playing the game by sending the
 		// request to the state manager to play.
-		wg = stateMan.Play(clientMan.Clients(), stateChan, errChan)
+		wg = stateMan.Play(ctx, clientMan.Clients(), turnChan, errChan)
 		// wait until the game is over or a timeout occurs
 		wg.Wait()
 	}()
 
 	go func() {
-		defer connMan.Close()
-		defer record.Close()
-		defer func() {
-			exitChan <- true
-		}()
-		for {
-			select {
-			case err := <-errChan:
-				switch err.(type) {
-				case ClientError:
-					log.Println("Client committed a sin: " + err.Error())
-					record.LogDisconnection(err.(ClientError).client)
-				default:
-					log.Println(err)
+		defer close(done)
+
+		handle(ctx, clientMan, record, listen, errChan, abortChan, turnChan)
+
+		// Shut down in order: stop the state manager, then close the recorder so
+		// that recordings are complete, then close the sockets, which stops
+		// the goroutines listening to them.
+		cancel()
+		playing.Wait()
+		record.Close()
+		connMan.Close()
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, wg := range listeners {
+			wg.Wait()
+		}
+	}()
+
+	return handler, done
+}
+
+// Handle errors, reconnections and state changes until the game is over, the
+// client manager gives up waiting for clients, or the context is done.
+func handle(
+	ctx context.Context,
+	clientMan ClientManager,
+	record GameRecorder,
+	listen func(GameClient),
+	errChan chan error,
+	abortChan chan bool,
+	turnChan chan Turn,
+) {
+	for {
+		select {
+		case err := <-errChan:
+			switch err.(type) {
+			case ClientError:
+				if err.(ClientError).err == ErrFlagged {
+					// running out of time is not a sin, the game decides the
+					// punishment
+					log.Println("Client " + err.(ClientError).client.Id() + " flagged.")
+					continue
 				}
-			case <-abortChan:
-				return
-			case state := <-stateChan:
-				// a state change has occurred
-				record.LogState(state)
-
-				if state.Finished() {
-					record.LogResult(state)
-					log.Printf("Result: %s\n", state.Result())
-					log.Println("Game over.")
-					return
+				switch e := err.(ClientError).err.(type) {
+				case MalformedActionError, IllegalActionError:
+					// the client has been warned, and the game decides the
+					// punishment
+					log.Println("Client " + err.(ClientError).client.Id() + " sent a " +
+						err.Error())
+					continue
+				case ViolationError:
+					// the client has been cut off, and the violation is kept apart
+					// from ordinary disconnections
+					log.Println("Client " + err.(ClientError).client.Id() + " broke a " +
+						"rule: " + e.Err.Error())
+					record.LogViolation(err.(ClientError).client, e)
+					clientMan.Banned(err.(ClientError).client)
+					continue
 				}
+				log.Println("Client committed a sin: " + err.Error())
+				record.LogDisconnection(err.(ClientError).client)
+				clientMan.Disconnected(err.(ClientError).client)
+			default:
+				log.Println(err)
+			}
+		case c := <-clientMan.Reconnections():
+			log.Println("Client " + c.Id() + " reconnected.")
+			record.LogReconnection(c)
+			listen(c)
+		case <-abortChan:
+			return
+		case <-ctx.Done():
+			log.Println("Game aborted.")
+			return
+		case turn := <-turnChan:
+			// a state change has occurred
+			record.LogTurn(turn)
+
+			if state := turn.Final; state != nil {
+				if a, ok := state.(*AdjudicatedState); ok {
+					log.Println("Game stopped: " + a.Reason + ".")
+				}
+				record.LogResult(state)
+				log.Printf("Result: %v\n", state.Result())
+				log.Println("Game over.")
+				return
 			}
 		}
-	}()
+	}
+}
 
-	return handler
+// Clients that do not talk over a websocket listen to their own transport.
+type Listener interface {
+	Listen(ctx context.Context) *sync.WaitGroup
 }
 
 // Listen for messages send from and received by this client in separate
-// non-blocking goroutines.
-func Listen(c GameClient) {
+// non-blocking goroutines. Both goroutines stop when the connection fails, and
+// a single error is sent along the client's error channel unless the context
+// is done. If the client reconnects, then Listen must be called again for the
+// new connection. Returns a waitgroup that finishes when both goroutines have
+// stopped, which happens once the connection is closed. Clients that send a
+// message larger than MaxMessageSize, or more than MessageBudget messages
+// between two state messages, are sent an error and disconnected, and the error
+// is a ViolationError. Clients that are listeners listen by themselves.
+func Listen(ctx context.Context, c GameClient) *sync.WaitGroup {
+	if l, ok := c.(Listener); ok {
+		return l.Listen(ctx)
+	}
+
+	conn := c.Conn()
+	conn.MaxPayloadBytes = MaxMessageSize
+	return listenOn(ctx, c, &websocketTransport{conn, ConnCodec(conn)})
+}
+
+// Returned by transports when a client sends a message larger than
+// MaxMessageSize.
+var errMessageTooLarge = errors.New(
+	"Message is larger than " + strconv.Itoa(MaxMessageSize) + " bytes.",
+)
+
+// A transport carries messages between the server and a single client.
+type transport interface {
+	send(*ServerMessage) error
+	receive(*ClientMessage) error
+	close() error
+}
+
+// Messages over a websocket in the codec negotiated by the client.
+type websocketTransport struct {
+	conn  *websocket.Conn
+	codec Codec
+}
+
+func (t *websocketTransport) send(msg *ServerMessage) error {
+	return t.codec.Send(t.conn, msg)
+}
+
+func (t *websocketTransport) receive(msg *ClientMessage) error {
+	err := t.codec.Receive(t.conn, msg)
+	if err == websocket.ErrFrameTooLarge {
+		return errMessageTooLarge
+	}
+	return err
+}
+
+func (t *websocketTransport) close() error {
+	return t.conn.Close()
+}
+
+// Listen to a client over any transport, as described by Listen.
+func listenOn(ctx context.Context, c GameClient, t transport) *sync.WaitGroup {
+	done := make(chan bool)
+	var once sync.Once
+	fail := func(err error) {
+		once.Do(func() {
+			close(done)
+			select {
+			case c.Error() <- ClientError{err, c}:
+			case <-ctx.Done():
+			}
+		})
+	}
+	violate := func(v ViolationError) {
+		t.send(&ServerMessage{
+			Type:    MessageError,
+			Code:    v.Code,
+			Message: v.Err.Error(),
+		})
+		fail(v)
+		t.close()
+	}
+	// the number of messages received since the last state message was sent
+	var received int32
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
 	go func() {
+		defer wg.Done()
 		for {
-			broadcast := <-c.Send()
-			err := websocket.JSON.Send(c.Conn(), &broadcast)
-			if err != nil {
-				c.Error() <- ClientError{err, c}
+			select {
+			case broadcast := <-c.Send():
+				if broadcast.Type == MessageState {
+					atomic.StoreInt32(&received, 0)
+				}
+				err := t.send(&broadcast)
+				if err != nil {
+					fail(err)
+					return
+				}
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
 
 	go func() {
+		defer wg.Done()
 		for {
 			var msg ClientMessage
-			err := websocket.JSON.Receive(c.Conn(), &msg)
-			if err != nil {
-				c.Error() <- ClientError{err, c}
+			err := t.receive(&msg)
+			if err == errMessageTooLarge {
+				violate(ViolationError{ErrorMessageTooLarge, err})
+				return
+			} else if err != nil {
+				fail(err)
+				return
+			}
+			if atomic.AddInt32(&received, 1) > MessageBudget {
+				violate(ViolationError{ErrorTooManyMessages, errors.New(
+					"More than " + strconv.Itoa(MessageBudget) +
+						" messages were sent in one turn.",
+				)})
+				return
 			}
 
-			c.Receive() <- msg
+			select {
+			case c.Receive() <- msg:
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
+
+	return &wg
+}
+
+// Forward errors from a client along the error channel until the context is
+// done.
+func forward(
+	ctx context.Context, c GameClient, errChan chan error,
+) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case err := <-c.Error():
+				report(ctx, errChan, err)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return &wg
+}
+
+// Send an error along the error channel, unless the context is done and
+// nobody is listening anymore.
+func report(ctx context.Context, errChan chan error, err error) {
+	select {
+	case errChan <- err:
+	case <-ctx.Done():
+	}
+}
+
+// Send a message to a client and wait for its action. The client's watchdog
+// is given as much time as the client's clock allows for the move. If the
+// client reconnects while the move is pending, then the message is sent again.
+// Note that if the client times out, then the action will be empty, so a state
+// can kill a player if the empty action is received to punish bad players.
+// Also returns how long the client took to respond. Gives up without an action
+// when the context is done.
+func request(
+	ctx context.Context, c GameClient, msg ServerMessage, errChan chan error,
+) (json.RawMessage, time.Duration) {
+	var action json.RawMessage
+	// throw away anything the client sent after its last deadline so that a
+	// late reply does not count as the next move
+	drain(c)
+
+	allowed := c.Clock().Start()
+	if allowed == 0 {
+		// the client has run out of time, so don't bother asking it
+		c.Clock().Stop()
+		log.Println("Client " + c.Id() + " has no time left")
+		return action, 0
+	}
+	start := time.Now()
+	c.Watchdog().SetTimeout(allowed)
+	msg.Clock = c.Clock().Status()
+	watchCh := c.Watchdog().Watch()
+	defer func() {
+		c.Watchdog().Stop()
+		if err := c.Clock().Stop(); err != nil {
+			report(ctx, errChan, ClientError{err, c})
+		}
+	}()
+
+	log.Println("Sending message to client " + c.Id())
+	select {
+	case c.Send() <- msg:
+	case <-watchCh:
+		report(ctx, errChan, errors.New("Client send timeout"))
+		return action, time.Since(start)
+	case <-ctx.Done():
+		return action, time.Since(start)
+	}
+
+	for {
+		select {
+		case msg := <-c.Receive():
+			action = msg.Action
+		case <-c.Reconnected():
+			// the client may have missed the message while it was disconnected
+			log.Println("Sending message again to client " + c.Id())
+			select {
+			case c.Send() <- msg:
+				continue
+			case <-watchCh:
+				report(ctx, errChan, errors.New("Client send timeout"))
+			case <-ctx.Done():
+			}
+		case <-ctx.Done():
+		case <-watchCh:
+			report(ctx, errChan, errors.New("Client receive timeout"))
+			notify(c, ServerMessage{
+				Type:    MessageWarning,
+				Code:    ErrorTimeout,
+				Turn:    msg.Turn,
+				Player:  msg.Player,
+				Message: "No action was received in time.",
+			})
+		}
+		break
+	}
+	log.Println("Got action '" + string(action) + "' from client " + c.Id())
+
+	return action, time.Since(start)
+}
+
+// Discard any messages a client has sent that are waiting to be received, and
+// any reconnections that happened before it was asked for a move.
+func drain(c GameClient) {
+	for {
+		select {
+		case <-c.Receive():
+		case <-c.Reconnected():
+		default:
+			return
+		}
+	}
 }
 
 // A simple connection manager that forwards all connections along the
-// connection channel in its handler.
+// connection channel in its handler, and keeps them open until the game is
+// over.
 type SimpleConnectionManager struct {
 	Connections []*websocket.Conn
-	ExitChans   map[*websocket.Conn]chan bool
+	closed      chan bool
+	once        sync.Once
+	mutex       sync.Mutex
 }
 
 func NewSimpleConnectionManager() *SimpleConnectionManager {
 	return &SimpleConnectionManager{
-		[]*websocket.Conn{},
-		map[*websocket.Conn]chan bool{},
+		Connections: []*websocket.Conn{},
+		closed:      make(chan bool),
 	}
 }
 
+// Build a websocket server that negotiates the codec of every connection and
+// sends the connection along the channel.
 func (m *SimpleConnectionManager) Handler(
+	ctx context.Context,
 	connChan chan *websocket.Conn,
-	errChan chan error,
-) websocket.Handler {
+) websocket.Server {
 
-	return websocket.Handler(func(conn *websocket.Conn) {
-		defer func() {
-			err := conn.Close()
-			if err != nil {
-				errChan <- err
-			}
-		}()
+	handler := websocket.Handler(func(conn *websocket.Conn) {
+		defer conn.Close()
+		// the handshake is read before anyone listens to the connection
+		conn.MaxPayloadBytes = MaxMessageSize
 
+		m.mutex.Lock()
 		m.Connections = append(m.Connections, conn)
-		connChan <- conn
+		m.mutex.Unlock()
 
-		// keep the connection alive while the agents play the game
-		exitChan := make(chan bool)
-		m.ExitChans[conn] = exitChan
-		<-exitChan
+		// nobody may be listening for connections anymore
+		select {
+		case connChan <- conn:
+		case <-ctx.Done():
+			return
+		case <-m.closed:
+			return
+		}
 
-		conn.Close()
+		// keep the connection alive while the agents play the game
+		select {
+		case <-ctx.Done():
+		case <-m.closed:
+		}
 	})
+
+	return websocket.Server{Handler: handler, Handshake: negotiate}
 }
 
+// Close every connection and stop every handler. Closing the connections stops
+// anything still reading from them. May be called more than once.
 func (m *SimpleConnectionManager) Close() {
+	m.once.Do(func() {
+		close(m.closed)
+	})
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	for _, c := range m.Connections {
-		m.ExitChans[c] <- true
+		c.Close()
 	}
 }
 
 // An authenticated client manager will require secret keys passed in for each
 // client id. If a client does not pass in a valid key, or passes in a
-// duplicate key, then it will be rejected.
+// duplicate key, then it will be rejected. Every client plays as the player
+// at the position of its id in the list of ids, no matter when it connects.
+// Clients that disconnect may be allowed to reconnect with the same key within
+// a grace period.
 type AuthenticatedClientManager struct {
 	constructor   func(id string, conn *websocket.Conn) GameClient
 	clients       []GameClient
+	players       []int
 	clientIds     []string
 	clientSecrets []string
 	timeout       time.Duration
+	grace         time.Duration
+	handshake     *Handshake
+	secrets       map[string]GameClient
+	pending       map[string]bool
+	disconnected  map[GameClient]time.Time
+	reconnecting  map[GameClient]bool
+	banned        map[GameClient]bool
+	reconnections chan GameClient
+	mutex         sync.Mutex
 }
 
 // Create a new simple client manager. Give it a constructor to create clients
@@ -251,15 +633,45 @@ func NewAuthenticatedClientManager(
 	timeout time.Duration,
 ) *AuthenticatedClientManager {
 	return &AuthenticatedClientManager{
-		constructor,
-		make([]GameClient, 0, len(clientIds)),
-		clientIds,
-		clientSecrets,
-		timeout,
+		constructor:   constructor,
+		clients:       make([]GameClient, 0, len(clientIds)),
+		players:       make([]int, 0, len(clientIds)),
+		clientIds:     append([]string{}, clientIds...),
+		clientSecrets: append([]string{}, clientSecrets...),
+		timeout:       timeout,
+		secrets:       map[string]GameClient{},
+		pending:       map[string]bool{},
+		disconnected:  map[GameClient]time.Time{},
+		reconnecting:  map[GameClient]bool{},
+		banned:        map[GameClient]bool{},
+		reconnections: make(chan GameClient),
 	}
 }
 
+// Allow clients that disconnect to reconnect within the grace period. By
+// default clients are not allowed to reconnect. Must be called before
+// registering clients.
+func (m *AuthenticatedClientManager) SetReconnectGrace(grace time.Duration) {
+	m.grace = grace
+}
+
+// Send every accepted client a hello message describing the game and reject
+// clients that speak an incompatible protocol. By default clients are sent
+// nothing until the game starts. Must be called before registering clients.
+func (m *AuthenticatedClientManager) SetHandshake(h *Handshake) {
+	m.handshake = h
+}
+
+// The outcome of the handshake with a connection that has a valid secret.
+type shaken struct {
+	conn   *websocket.Conn
+	secret string
+	player int
+	err    error
+}
+
 func (m *AuthenticatedClientManager) Register(
+	ctx context.Context,
 	connChan chan *websocket.Conn,
 ) *sync.WaitGroup {
 
@@ -269,31 +681,64 @@ func (m *AuthenticatedClientManager) Register(
 	watchdog := NewWatchdog(m.timeout)
 	watchChan := watchdog.Watch()
 
+	// handshakes run beside the loop accepting connections, so that a slow
+	// client does not hold up the others
+	shakes := make(chan shaken)
+	stopped := make(chan struct{})
+
 	go func() {
+		defer close(stopped)
 		for {
 			select {
 			case conn := <-connChan:
 				log.Println("Client received")
 
+				secret := conn.Request().Header.Get("Authorization")
 				id, err := m.Validate(conn)
 				if err != nil {
 					// Client sent invalid authorization parameters
 					log.Println("Client rejected: " + err.Error())
 					conn.Close()
-				} else {
-					log.Println("Client accepted")
-					client := m.constructor(id, conn)
-					m.clients = append(m.clients, client)
+					continue
 				}
+				m.pending[secret] = true
+				go func(player int) {
+					err := m.shake(conn, player)
+					select {
+					case shakes <- shaken{conn, secret, player, err}:
+					case <-stopped:
+						conn.Close()
+					}
+				}(m.player(id))
+			case s := <-shakes:
+				delete(m.pending, s.secret)
+				if s.err != nil {
+					// Client failed the handshake, but may try again with the
+					// same secret
+					log.Println("Client rejected: " + s.err.Error())
+					s.conn.Close()
+					continue
+				}
+
+				log.Println("Client accepted")
+				m.accept(s.conn, s.secret, s.player)
 
 				if len(m.clients) == cap(m.clients) {
+					watchdog.Stop()
 					wg.Done()
+					if m.grace > 0 {
+						m.listenReconnect(ctx, connChan)
+					}
 					return
 				}
 			case <-watchChan:
 				// Quit the goroutine if the watchdog times out.
 				wg.Done()
 				return
+			case <-ctx.Done():
+				watchdog.Stop()
+				wg.Done()
+				return
 			}
 		}
 	}()
@@ -301,6 +746,184 @@ func (m *AuthenticatedClientManager) Register(
 	return &wg
 }
 
+// Create the client of an accepted connection, use up its secret, and keep the
+// clients in player order.
+func (m *AuthenticatedClientManager) accept(
+	conn *websocket.Conn, secret string, player int,
+) {
+	client := m.constructor(m.clientIds[player], conn)
+	m.secrets[secret] = client
+
+	i := 0
+	for i < len(m.players) && m.players[i] < player {
+		i++
+	}
+	m.clients = append(m.clients, nil)
+	copy(m.clients[i+1:], m.clients[i:])
+	m.clients[i] = client
+	m.players = append(m.players, 0)
+	copy(m.players[i+1:], m.players[i:])
+	m.players[i] = player
+}
+
+// Accept connections from clients that have disconnected and swap the new
+// connection into the existing client, until the context is done.
+func (m *AuthenticatedClientManager) listenReconnect(
+	ctx context.Context,
+	connChan chan *websocket.Conn,
+) {
+	for {
+		var conn *websocket.Conn
+		select {
+		case conn = <-connChan:
+		case <-ctx.Done():
+			return
+		}
+
+		client, err := m.Reconnect(conn)
+		if err != nil {
+			log.Println("Client reconnect rejected: " + err.Error())
+			conn.Close()
+			continue
+		}
+		go m.reconnect(ctx, conn, client)
+	}
+}
+
+// Run the handshake with a client that is reconnecting, and swap the new
+// connection into the client if it passes.
+func (m *AuthenticatedClientManager) reconnect(
+	ctx context.Context, conn *websocket.Conn, client GameClient,
+) {
+	err := m.shake(conn, m.index(client))
+	m.reconnected(client, err == nil)
+	if err != nil {
+		// the client is still gone, but may try again within the grace period
+		log.Println("Client reconnect rejected: " + err.Error())
+		conn.Close()
+		return
+	}
+
+	log.Println("Client reconnected")
+	client.Reconnect(conn)
+	select {
+	case m.reconnections <- client:
+	case <-ctx.Done():
+	}
+}
+
+// Check that a connection has the secret of a client that disconnected within
+// the grace period, and was not banned, and return the client. The client stays
+// disconnected until its handshake is over.
+func (m *AuthenticatedClientManager) Reconnect(
+	conn *websocket.Conn,
+) (GameClient, error) {
+	secret := conn.Request().Header.Get("Authorization")
+	client, ok := m.secrets[secret]
+	if !ok {
+		return nil, errors.New("Invalid secret.")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.banned[client] {
+		return nil, errors.New("Client was banned.")
+	}
+	t, ok := m.disconnected[client]
+	if !ok {
+		return nil, errors.New("Client is still connected.")
+	}
+	if time.Since(t) > m.grace {
+		return nil, errors.New("Reconnect grace period is over.")
+	}
+	if m.reconnecting[client] {
+		return nil, errors.New("Client is already reconnecting.")
+	}
+	m.reconnecting[client] = true
+
+	return client, nil
+}
+
+// Finish reconnecting a client, which is connected again if its handshake
+// passed.
+func (m *AuthenticatedClientManager) reconnected(c GameClient, ok bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.reconnecting, c)
+	if ok {
+		delete(m.disconnected, c)
+	}
+}
+
+// Run the handshake with a client if there is one.
+func (m *AuthenticatedClientManager) shake(conn *websocket.Conn, player int) error {
+	if m.handshake == nil {
+		return nil
+	}
+
+	hello, err := m.handshake.Shake(conn, player, len(m.clientIds))
+	if err != nil {
+		return err
+	}
+	log.Println("Client is using " + hello.SDK + " " + hello.SDKVersion)
+
+	return nil
+}
+
+// Find the player index of a registered client.
+func (m *AuthenticatedClientManager) index(c GameClient) int {
+	for i, client := range m.clients {
+		if client == c {
+			return m.players[i]
+		}
+	}
+	return -1
+}
+
+// Find the player that a client id plays as.
+func (m *AuthenticatedClientManager) player(id string) int {
+	for i, clientId := range m.clientIds {
+		if clientId == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *AuthenticatedClientManager) Disconnected(c GameClient) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.disconnected[c]; !ok {
+		m.disconnected[c] = time.Now()
+	}
+}
+
+// Close the connection of a client that broke a rule. Its secret is refused
+// from then on, however long the grace period is.
+func (m *AuthenticatedClientManager) Banned(c GameClient) {
+	m.mutex.Lock()
+	m.banned[c] = true
+	if _, ok := m.disconnected[c]; !ok {
+		m.disconnected[c] = time.Now()
+	}
+	m.mutex.Unlock()
+
+	if conn := c.Conn(); conn != nil {
+		conn.Close()
+	}
+}
+
+func (m *AuthenticatedClientManager) Reconnections() chan GameClient {
+	return m.reconnections
+}
+
+// Check the secret of a connection and return the id of its client. Secrets
+// that were used by an accepted client, or by a client that is still shaking
+// hands, are rejected. Secrets are only used up once the client is accepted,
+// so a client that fails the handshake can try again.
 func (m *AuthenticatedClientManager) Validate(
 	conn *websocket.Conn,
 ) (string, error) {
@@ -309,26 +932,18 @@ func (m *AuthenticatedClientManager) Validate(
 		// no key sent by client
 		return "", errors.New("Secret is required.")
 	}
+	if _, ok := m.secrets[secret]; ok || m.pending[secret] {
+		return "", errors.New("Secret is already in use.")
+	}
 
 	// check if secret is in the list
-	valid := false
 	for i, k := range m.clientSecrets {
 		if k == secret {
-			valid = true
-			id := m.clientIds[i]
-			// remove used secrets
-			m.clientSecrets = append(m.clientSecrets[:i], m.clientSecrets[i+1:]...)
-			m.clientIds = append(m.clientIds[:i], m.clientIds[i+1:]...)
-
-			return id, nil
+			return m.clientIds[i], nil
 		}
 	}
 
-	if valid == false {
-		return "", errors.New("Invalid secret.")
-	}
-
-	return "", nil
+	return "", errors.New("Invalid secret.")
 }
 
 func (m *AuthenticatedClientManager) Clients() []GameClient {
@@ -342,48 +957,75 @@ func (m *AuthenticatedClientManager) Ready() bool {
 // The game recorder records the game state every time it changes and whether
 // a client connects as expected or disconnects unexpectedly. This allows
 // the sandbox service to adequately punish clients which are not well-behaved,
-// and send game results to the scoreboard service.
+// and send game results to the scoreboard service. The state log is nil if
+// states are not recorded.
 type SimpleGameRecorder struct {
 	StateLog      *os.File
 	ResultLog     *os.File
 	ConnectLog    *os.File
 	DisconnectLog *os.File
+	ReconnectLog  *os.File
+	ViolationLog  *os.File
 }
 
 func NewSimpleGameRecorder(dir string) (*SimpleGameRecorder, error) {
-	f := os.O_APPEND | os.O_WRONLY | os.O_CREATE | os.O_TRUNC
-	p := os.FileMode(0600)
-	stateLog, err := os.OpenFile(path.Join(dir, sandbox.StateLogFile), f, p)
+	r, err := NewSimpleEventRecorder(dir)
 	if err != nil {
 		return nil, err
 	}
-	resultLog, err := os.OpenFile(path.Join(dir, sandbox.ResultLogFile), f, p)
+	f := os.O_APPEND | os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	p := os.FileMode(0600)
+	r.StateLog, err = os.OpenFile(path.Join(dir, sandbox.StateLogFile), f, p)
 	if err != nil {
+		r.Close()
 		return nil, err
 	}
-	connectLog, err := os.OpenFile(path.Join(dir, sandbox.ConnectLogFile), f, p)
-	if err != nil {
-		return nil, err
+
+	return r, nil
+}
+
+// Create a new simple game recorder that records results, connections and
+// violations, but not the state of every turn, which grows with the square of
+// the length of the game. Replays record the states instead.
+func NewSimpleEventRecorder(dir string) (*SimpleGameRecorder, error) {
+	f := os.O_APPEND | os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	p := os.FileMode(0600)
+	names := []string{
+		sandbox.ResultLogFile,
+		sandbox.ConnectLogFile,
+		sandbox.DisconnectLogFile,
+		sandbox.ReconnectLogFile,
+		sandbox.ViolationLogFile,
 	}
-	disconnectLog, err := os.OpenFile(path.Join(dir, sandbox.DisconnectLogFile), f, p)
-	if err != nil {
-		return nil, err
+	files := []*os.File{}
+	for _, name := range names {
+		file, err := os.OpenFile(path.Join(dir, name), f, p)
+		if err != nil {
+			for _, file := range files {
+				file.Close()
+			}
+			return nil, err
+		}
+		files = append(files, file)
 	}
 
 	return &SimpleGameRecorder{
-		stateLog,
-		resultLog,
-		connectLog,
-		disconnectLog,
+		nil,
+		files[0],
+		files[1],
+		files[2],
+		files[3],
+		files[4],
 	}, nil
 }
 
-func (r *SimpleGameRecorder) LogState(s GameState) error {
-	b, err := json.Marshal(s)
-	if err != nil {
-		return err
+func (r *SimpleGameRecorder) LogTurn(t Turn) error {
+	if r.StateLog == nil {
+		return nil
 	}
-	_, err = r.StateLog.Write(append(b, '\n'))
+	// the encoded state is shared with other recorders, so append to a copy
+	line := append(t.State[:len(t.State):len(t.State)], '\n')
+	_, err := r.StateLog.Write(line)
 	if err != nil {
 		return err
 	}
@@ -392,7 +1034,7 @@ func (r *SimpleGameRecorder) LogState(s GameState) error {
 }
 
 func (r *SimpleGameRecorder) LogResult(s GameState) error {
-	b, err := json.Marshal(s.Result())
+	b, err := json.Marshal(Rank(s))
 	if err != nil {
 		return err
 	}
@@ -422,10 +1064,31 @@ func (r *SimpleGameRecorder) LogDisconnection(c GameClient) error {
 	return nil
 }
 
-func (r *SimpleGameRecorder) Close() error {
-	if err := r.StateLog.Close(); err != nil {
+func (r *SimpleGameRecorder) LogReconnection(c GameClient) error {
+	_, err := r.ReconnectLog.WriteString(c.Id() + "\n")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Violations are logged as the client id and the error code.
+func (r *SimpleGameRecorder) LogViolation(c GameClient, v ViolationError) error {
+	_, err := r.ViolationLog.WriteString(c.Id() + " " + v.Code + "\n")
+	if err != nil {
 		return err
 	}
+
+	return nil
+}
+
+func (r *SimpleGameRecorder) Close() error {
+	if r.StateLog != nil {
+		if err := r.StateLog.Close(); err != nil {
+			return err
+		}
+	}
 	if err := r.ResultLog.Close(); err != nil {
 		return err
 	}
@@ -435,17 +1098,98 @@ func (r *SimpleGameRecorder) Close() error {
 	if err := r.ConnectLog.Close(); err != nil {
 		return err
 	}
+	if err := r.ReconnectLog.Close(); err != nil {
+		return err
+	}
+	if err := r.ViolationLog.Close(); err != nil {
+		return err
+	}
 	return nil
 }
 
+// A recorder that passes everything along to several other recorders, e.g.,
+// to write logs and broadcast to spectators at the same time. Returns the
+// first error encountered, but always logs to every recorder.
+type MultiGameRecorder struct {
+	Recorders []GameRecorder
+}
+
+func NewMultiGameRecorder(recorders ...GameRecorder) *MultiGameRecorder {
+	return &MultiGameRecorder{recorders}
+}
+
+func (r *MultiGameRecorder) each(f func(GameRecorder) error) error {
+	var first error
+	for _, recorder := range r.Recorders {
+		if err := f(recorder); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (r *MultiGameRecorder) LogTurn(t Turn) error {
+	return r.each(func(g GameRecorder) error { return g.LogTurn(t) })
+}
+
+func (r *MultiGameRecorder) LogResult(s GameState) error {
+	return r.each(func(g GameRecorder) error { return g.LogResult(s) })
+}
+
+func (r *MultiGameRecorder) LogConnection(c GameClient) error {
+	return r.each(func(g GameRecorder) error { return g.LogConnection(c) })
+}
+
+func (r *MultiGameRecorder) LogDisconnection(c GameClient) error {
+	return r.each(func(g GameRecorder) error { return g.LogDisconnection(c) })
+}
+
+func (r *MultiGameRecorder) LogReconnection(c GameClient) error {
+	return r.each(func(g GameRecorder) error { return g.LogReconnection(c) })
+}
+
+func (r *MultiGameRecorder) LogViolation(c GameClient, v ViolationError) error {
+	return r.each(func(g GameRecorder) error { return g.LogViolation(c, v) })
+}
+
+func (r *MultiGameRecorder) Close() error {
+	return r.each(func(g GameRecorder) error { return g.Close() })
+}
+
 type ClientMessage struct {
-	Action string `json:"action"`
+	// The action is decoded by the game state when it is committed.
+	Action json.RawMessage `json:"action"`
 }
 
+// Every message sent to a client has a type. State messages carry the state
+// and the actions the player can take, while warning and error messages carry
+// a machine-readable code and a description of what went wrong.
 type ServerMessage struct {
+	Type    string      `json:"type"`
+	Turn    int         `json:"turn"`
 	Player  int         `json:"player"`
-	Actions interface{} `json:"actions"`
-	State   interface{} `json:"state"`
+	Actions interface{} `json:"actions,omitempty"`
+	State   interface{} `json:"state,omitempty"`
+	// Updates only inform the client of the state, the client should not
+	// respond to them with an action.
+	Update bool `json:"update,omitempty"`
+	// The time the client has left, if the game is played with a time bank.
+	Clock   *ClockStatus `json:"clock,omitempty"`
+	Code    string       `json:"code,omitempty"`
+	Message string       `json:"message,omitempty"`
+}
+
+// Build the state message asking player p for an action. The actions and the
+// view of the player are encoded for its client right away, because the state
+// keeps changing while the message is on its way.
+func stateMessage(state GameState, c GameClient, turn, p int) ServerMessage {
+	return ServerMessage{
+		Type:    MessageState,
+		Turn:    turn,
+		Player:  p,
+		Actions: freeze(c, state.Actions(p)),
+		State:   freeze(c, state.View(p)),
+	}
 }
 
 // This is an error that is associated with a client so that we can adequately
@@ -474,10 +1218,19 @@ func NewWatchdog(timeout time.Duration) *Watchdog {
 // Start the watchdog on a separate goroutine. Will call Done() on the given
 // waitgroup when it times out unless it is stopped before the timer is done.
 func (w *Watchdog) Watch() chan bool {
+	// every watch gets its own buffered channel so that a timeout which fires
+	// just as the watchdog is stopped can not leak into the next watch
+	ch := make(chan bool, 1)
+	w.ch = ch
 	w.timer = time.AfterFunc(w.timeout, func() {
-		w.ch <- true
+		ch <- true
 	})
-	return w.ch
+	return ch
+}
+
+// Change how long the watchdog waits the next time it is started.
+func (w *Watchdog) SetTimeout(timeout time.Duration) {
+	w.timeout = timeout
 }
 
 // Stop the watchdog from sending an error when the timeout is reached.
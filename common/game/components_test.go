@@ -1,19 +1,23 @@
 package game
 
 import (
+	"context"
 	"github.com/crestonbunch/botbox/services/sandbox"
 	"golang.org/x/net/websocket"
 	"io/ioutil"
 	"math"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 )
 
-func setupTestServer(handler websocket.Handler) (string, *httptest.Server) {
+func setupTestServer(handler http.Handler) (string, *httptest.Server) {
 	ts := httptest.NewServer(handler)
 
 	// change URL scheme from http:// to ws:// on the mock server
@@ -25,26 +29,24 @@ func setupTestServer(handler websocket.Handler) (string, *httptest.Server) {
 
 func TestSimpleConnectionManager(t *testing.T) {
 	m := NewSimpleConnectionManager()
-	defer m.Close()
 	connChan := make(chan *websocket.Conn)
-	errChan := make(chan error)
 
-	handler := m.Handler(connChan, errChan)
+	handler := m.Handler(context.Background(), connChan)
 	url, ts := setupTestServer(handler)
 	defer ts.Close()
+	defer m.Close()
 	origin := "http://localhost/"
 
-	_, err := websocket.Dial(url, "", origin)
+	conn, err := websocket.Dial(url, "", origin)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
+	defer conn.Close()
 
 	select {
 	case <-connChan:
-	case err := <-errChan:
-		t.Error(err)
-	default:
-		t.Error("No connection or error received.")
+	case <-time.After(time.Second):
+		t.Error("No connection received.")
 	}
 
 	if len(m.Connections) != 1 {
@@ -52,6 +54,50 @@ func TestSimpleConnectionManager(t *testing.T) {
 	}
 }
 
+func TestSimpleConnectionManagerClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewSimpleConnectionManager()
+	connChan := make(chan *websocket.Conn)
+
+	url, ts := setupTestServer(m.Handler(ctx, connChan))
+	defer ts.Close()
+	origin := "http://localhost/"
+
+	conns := []*websocket.Conn{}
+	for i := 0; i < 2; i++ {
+		conn, err := websocket.Dial(url, "", origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	// only the first connection is taken, the second handler is left waiting
+	<-connChan
+
+	closed := make(chan bool)
+	go func() {
+		m.Close()
+		m.Close()
+		closed <- true
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked.")
+	}
+
+	for _, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		var msg ServerMessage
+		err := websocket.JSON.Receive(conn, &msg)
+		if err == nil {
+			t.Error("Connection was not closed.")
+		}
+	}
+}
+
 func TestAuthenticatedClientManager(t *testing.T) {
 	connChan := make(chan *websocket.Conn)
 	ids := []string{"id1", "id2"}
@@ -65,11 +111,11 @@ func TestAuthenticatedClientManager(t *testing.T) {
 		ConnTimeout,
 	)
 
-	wg := m.Register(connChan)
+	wg := m.Register(context.Background(), connChan)
 
-	url, ts := setupTestServer(func(conn *websocket.Conn) {
+	url, ts := setupTestServer(websocket.Handler(func(conn *websocket.Conn) {
 		connChan <- conn
-	})
+	}))
 	defer ts.Close()
 	origin := "http://localhost/"
 
@@ -103,15 +149,15 @@ func TestAuthenticatedTimeout(t *testing.T) {
 		},
 		ids,
 		secrets,
-		time.Millisecond,
+		100*time.Millisecond,
 	)
 
 	start := time.Now()
-	wg := m.Register(connChan)
+	wg := m.Register(context.Background(), connChan)
 
-	url, ts := setupTestServer(func(conn *websocket.Conn) {
+	url, ts := setupTestServer(websocket.Handler(func(conn *websocket.Conn) {
 		connChan <- conn
-	})
+	}))
 	defer ts.Close()
 	origin := "http://localhost/"
 
@@ -140,8 +186,8 @@ func TestAuthenticatedTimeout(t *testing.T) {
 	}
 
 	// TODO: this is a janky way of testing timeouts
-	if math.Abs(float64(duration-time.Millisecond)) < 0.1 {
-		t.Error("Client manager did not timeout in 1 ms")
+	if math.Abs(float64(duration-100*time.Millisecond)) < 0.1 {
+		t.Error("Client manager did not timeout in 100 ms")
 	}
 }
 
@@ -159,11 +205,11 @@ func TestAuthenticatedNoSecret(t *testing.T) {
 	)
 
 	start := time.Now()
-	wg := m.Register(connChan)
+	wg := m.Register(context.Background(), connChan)
 
-	url, ts := setupTestServer(func(conn *websocket.Conn) {
+	url, ts := setupTestServer(websocket.Handler(func(conn *websocket.Conn) {
 		connChan <- conn
-	})
+	}))
 	defer ts.Close()
 	origin := "http://localhost/"
 
@@ -210,11 +256,11 @@ func TestAuthenticatedBadAgents(t *testing.T) {
 		ConnTimeout,
 	)
 
-	m.Register(connChan)
+	m.Register(context.Background(), connChan)
 
-	url, ts := setupTestServer(func(conn *websocket.Conn) {
+	url, ts := setupTestServer(websocket.Handler(func(conn *websocket.Conn) {
 		connChan <- conn
-	})
+	}))
 	defer ts.Close()
 	origin := "http://localhost/"
 
@@ -246,9 +292,9 @@ func TestSimpleGameRecorder(t *testing.T) {
 	defer r.Close()
 
 	testState := &mockState{[]int{0, 0}}
-	r.LogState(testState)
+	r.LogTurn(takeTurn(0, nil, nil, testState))
 	testState = &mockState{[]int{1, 0}}
-	r.LogState(testState)
+	r.LogTurn(takeTurn(0, nil, nil, testState))
 
 	if f, err := os.Open(path.Join(dir, sandbox.StateLogFile)); err != nil {
 		t.Error(err)
@@ -274,7 +320,7 @@ func TestSimpleGameRecorder(t *testing.T) {
 		if err != nil {
 			t.Error(err)
 		}
-		if string(contents) != "[1,-1]" {
+		if string(contents) != `[{"place":1},{"place":2}]` {
 			t.Error("GameRecorder did not record correct result.")
 		}
 	}
@@ -315,15 +361,148 @@ func TestSimpleGameRecorder(t *testing.T) {
 		}
 	}
 
+	testClient = &SynchronizedGameClient{id: "123abc"}
+	r.LogReconnection(testClient)
+
+	if f, err := os.Open(path.Join(dir, sandbox.ReconnectLogFile)); err != nil {
+		t.Error(err)
+	} else {
+		defer f.Close()
+		contents, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Error(err)
+		}
+		if string(contents) != "123abc\n" {
+			t.Error("GameRecorder did not record correct reconnections.")
+		}
+	}
+
+	r.LogViolation(testClient, ViolationError{ErrorTooManyMessages, nil})
+
+	if f, err := os.Open(path.Join(dir, sandbox.ViolationLogFile)); err != nil {
+		t.Error(err)
+	} else {
+		defer f.Close()
+		contents, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Error(err)
+		}
+		if string(contents) != "123abc too_many_messages\n" {
+			t.Error("GameRecorder did not record correct violations.")
+		}
+	}
+}
+
+// Listen to a client on a test server, and return the error it stops with
+// after the given function has sent messages from the other end.
+func listenUntilError(t *testing.T, send func(conn *websocket.Conn)) (
+	ClientError, ServerMessage,
+) {
+	errs := make(chan ClientError, 1)
+	url, ts := setupTestServer(websocket.Handler(func(conn *websocket.Conn) {
+		c := NewSynchronizedGameClient("1", conn, time.Second, FixedTimeControl(time.Second))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		wg := Listen(ctx, c)
+		go func() {
+			for range c.Receive() {
+			}
+		}()
+		errs <- <-c.Error()
+		cancel()
+		conn.Close()
+		wg.Wait()
+		close(c.Receive())
+	}))
+	defer ts.Close()
+
+	conn, err := websocket.Dial(url, "", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	send(conn)
+
+	var msg ServerMessage
+	websocket.JSON.Receive(conn, &msg)
+	select {
+	case err := <-errs:
+		return err, msg
+	case <-time.After(time.Second):
+		t.Fatal("Client was not disconnected")
+	}
+	return ClientError{}, msg
+}
+
+func TestSimpleEventRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewSimpleEventRecorder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.LogTurn(takeTurn(0, nil, nil, &mockState{[]int{0, 0}}))
+	r.LogResult(&mockState{[]int{12, 4}})
+	err = r.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, err := os.Stat(path.Join(dir, sandbox.StateLogFile)); !os.IsNotExist(err) {
+		t.Error("Event recorder wrote a state log")
+	}
+	b, err := ioutil.ReadFile(path.Join(dir, sandbox.ResultLogFile))
+	if err != nil || string(b) != `[{"place":1},{"place":2}]` {
+		t.Error("Event recorder did not record the result")
+	}
+}
+
+func TestListenMessageTooLarge(t *testing.T) {
+	err, msg := listenUntilError(t, func(conn *websocket.Conn) {
+		big := strings.Repeat("a", MaxMessageSize)
+		websocket.JSON.Send(conn, ClientMessage{StringAction(big)})
+	})
+
+	v, ok := err.err.(ViolationError)
+	if !ok || v.Code != ErrorMessageTooLarge || err.client.Id() != "1" {
+		t.Error("Large message was not a violation: " + err.Error())
+	}
+	if msg.Type != MessageError || msg.Code != ErrorMessageTooLarge {
+		t.Error("Client was not told why it was disconnected")
+	}
+}
+
+func TestListenMessageBudget(t *testing.T) {
+	err, msg := listenUntilError(t, func(conn *websocket.Conn) {
+		for i := 0; i <= MessageBudget; i++ {
+			websocket.JSON.Send(conn, ClientMessage{StringAction("1")})
+		}
+	})
+
+	v, ok := err.err.(ViolationError)
+	if !ok || v.Code != ErrorTooManyMessages {
+		t.Error("Flooding the server was not a violation: " + err.Error())
+	}
+	if msg.Type != MessageError || msg.Code != ErrorTooManyMessages {
+		t.Error("Client was not told why it was disconnected")
+	}
 }
 
 func mockTwoPlayerGame() *mockState {
 	return &mockState{[]int{0, 0}}
 }
 
-type mockGameRecorder struct{}
+type mockGameRecorder struct {
+	disconnections []string
+	reconnections  []string
+	violations     []string
+}
 
-func (r *mockGameRecorder) LogState(s GameState) error {
+func (r *mockGameRecorder) LogTurn(t Turn) error {
 	return nil
 }
 
@@ -336,6 +515,17 @@ func (r *mockGameRecorder) LogConnection(c GameClient) error {
 }
 
 func (r *mockGameRecorder) LogDisconnection(c GameClient) error {
+	r.disconnections = append(r.disconnections, c.Id())
+	return nil
+}
+
+func (r *mockGameRecorder) LogReconnection(c GameClient) error {
+	r.reconnections = append(r.reconnections, c.Id())
+	return nil
+}
+
+func (r *mockGameRecorder) LogViolation(c GameClient, v ViolationError) error {
+	r.violations = append(r.violations, c.Id()+" "+v.Code)
 	return nil
 }
 
@@ -402,3 +592,135 @@ func (s *mockState) Result() []int {
 
 	return result
 }
+
+// Wait for the number of goroutines to fall back to what it was before a test,
+// and fail with a dump of every goroutine if it does not.
+func checkGoroutines(t *testing.T, before int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			n := runtime.Stack(buf, true)
+			t.Errorf(
+				"%d goroutines leaked:\n%s",
+				runtime.NumGoroutine()-before, buf[:n],
+			)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Start a mock game between two players on a test server. Connected players
+// send the given actions until their connection is closed.
+func startMockGame(
+	ctx context.Context, t *testing.T, timeout time.Duration, actions []string,
+) (*mockState, <-chan struct{}, func()) {
+	secrets := []string{"secret1", "secret2"}
+	state := &mockState{[]int{0, 0}}
+	stateMan := NewSynchronizedStateManager(state, timeout)
+	clientMan := NewAuthenticatedClientManager(
+		stateMan.NewClient,
+		[]string{"id1", "id2"},
+		secrets,
+		timeout,
+	)
+	handler, done := GameHandler(
+		ctx,
+		NewSimpleConnectionManager(),
+		clientMan,
+		stateMan,
+		&mockGameRecorder{},
+	)
+
+	url, ts := setupTestServer(handler)
+	origin := "http://localhost/"
+	conns := []*websocket.Conn{}
+	for i, action := range actions {
+		config, err := websocket.NewConfig(url, origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Header.Add("Authorization", secrets[i])
+		conn, err := websocket.DialConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+
+		go func(conn *websocket.Conn, action string) {
+			for {
+				var msg ServerMessage
+				if websocket.JSON.Receive(conn, &msg) != nil {
+					return
+				}
+				if msg.Type != MessageState || action == "" {
+					continue
+				}
+				reply := ClientMessage{Action: StringAction(action)}
+				websocket.JSON.Send(conn, &reply)
+			}
+		}(conn, action)
+	}
+
+	return state, done, func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		ts.Close()
+	}
+}
+
+func TestGameHandlerShutdown(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	state, done, cleanup := startMockGame(
+		context.Background(), t, time.Second, []string{"1", "3"},
+	)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Game did not finish.")
+	}
+	cleanup()
+
+	if !state.Finished() {
+		t.Error("Game did not finish!")
+	}
+	checkGoroutines(t, before)
+}
+
+func TestGameHandlerCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// the players never answer, so the game would wait on them for a minute
+	_, done, cleanup := startMockGame(ctx, t, time.Minute, []string{"", ""})
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Cancelled game did not shut down.")
+	}
+	cleanup()
+
+	checkGoroutines(t, before)
+}
+
+func TestGameHandlerConnectTimeout(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	// only one of the two players connects
+	_, done, cleanup := startMockGame(
+		context.Background(), t, 50*time.Millisecond, []string{"1"},
+	)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Game did not give up waiting for players.")
+	}
+	cleanup()
+
+	checkGoroutines(t, before)
+}
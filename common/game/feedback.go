@@ -0,0 +1,102 @@
+package game
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// How long the server waits to deliver feedback to a client before giving up.
+const NotifyTimeout = 100 * time.Millisecond
+
+// The types of message sent to clients.
+const (
+	MessageHello   = "hello"
+	MessageState   = "state"
+	MessageWarning = "warning"
+	MessageError   = "error"
+)
+
+// Machine-readable codes sent to clients in error and warning messages.
+const (
+	ErrorVersionMismatch = "version_mismatch"
+	ErrorBadHandshake    = "bad_handshake"
+	ErrorTimeout         = "timeout"
+	ErrorIllegalAction   = "illegal_action"
+	ErrorMalformedJSON   = "malformed_json"
+	ErrorEliminated      = "eliminated"
+	ErrorMessageTooLarge = "message_too_large"
+	ErrorTooManyMessages = "too_many_messages"
+)
+
+// Game states that use string actions may implement this interface so that
+// clients are warned when they send an action that is not allowed. The action
+// is still committed, so the game decides the punishment.
+type Validator interface {
+	Validate(p int, a string) bool
+}
+
+// Game states may implement this interface so that clients are told when they
+// are knocked out of the game.
+type Eliminator interface {
+	Eliminated(p int) bool
+}
+
+// Sent as a client error when a client sends an action the game does not
+// allow.
+type IllegalActionError struct {
+	Err error
+}
+
+func (e IllegalActionError) Error() string {
+	return "Illegal action: " + e.Err.Error()
+}
+
+var errNotAllowed = errors.New("Action is not allowed.")
+
+// Sent as a client error when a client breaks a rule of the connection, e.g.,
+// by sending a message that is too large. Unlike a bad action, the client is
+// disconnected, and the violation is recorded. The code is one of the error
+// codes sent to clients.
+type ViolationError struct {
+	Code string
+	Err  error
+}
+
+func (e ViolationError) Error() string {
+	return "Rule violation: " + e.Err.Error()
+}
+
+// Send a warning or error message to a client. Feedback is best effort, so a
+// client that is not ready to receive it does not hold up the game.
+func notify(c GameClient, msg ServerMessage) {
+	select {
+	case c.Send() <- msg:
+	case <-time.After(NotifyTimeout):
+		log.Println("Could not send " + msg.Code + " to client " + c.Id())
+	}
+}
+
+// Tell every client that was knocked out during this turn that it has been
+// eliminated. Clients that have already been told are marked in the list.
+func notifyEliminated(
+	s GameState, clients []GameClient, turn int, told []bool,
+) {
+	e, ok := s.(Eliminator)
+	if !ok {
+		return
+	}
+
+	for i, c := range clients {
+		if !told[i] && e.Eliminated(i) {
+			told[i] = true
+			notify(c, ServerMessage{
+				Type:    MessageError,
+				Code:    ErrorEliminated,
+				Turn:    turn,
+				Player:  i,
+				Message: "You have been eliminated.",
+			})
+		}
+	}
+}
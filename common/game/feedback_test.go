@@ -0,0 +1,98 @@
+package game
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimeoutWarning(t *testing.T) {
+	state := mockTwoPlayerGame()
+	turnChan := make(chan Turn)
+	errChan := make(chan error)
+	stateMan := NewSynchronizedStateManager(state, 20*time.Millisecond)
+
+	clients := []GameClient{
+		stateMan.NewClient("1", nil),
+		stateMan.NewClient("2", nil),
+	}
+
+	wg := stateMan.Play(context.Background(), clients, turnChan, errChan)
+
+	warnings := 0
+	go func() {
+		for {
+			select {
+			case msg := <-clients[0].Send():
+				// player 1 never responds
+				if msg.Type == MessageWarning {
+					if msg.Code != ErrorTimeout || msg.Turn != warnings {
+						t.Error("Player 1 was not warned about the right timeout")
+					}
+					warnings++
+				}
+			case <-clients[1].Send():
+				clients[1].Receive() <- ClientMessage{StringAction("3")}
+			case <-errChan:
+			case <-turnChan:
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	if warnings != 4 {
+		t.Errorf("Player 1 was warned %d times instead of 4", warnings)
+	}
+}
+
+func TestEliminatedError(t *testing.T) {
+	state := &mockEliminatorState{mockState{[]int{0, 0}}}
+	turnChan := make(chan Turn)
+	errChan := make(chan error)
+	stateMan := NewSynchronizedStateManager(state, time.Second)
+
+	clients := []GameClient{
+		stateMan.NewClient("1", nil),
+		stateMan.NewClient("2", nil),
+	}
+
+	wg := stateMan.Play(context.Background(), clients, turnChan, errChan)
+
+	eliminated := []int{}
+	go func() {
+		for {
+			select {
+			case msg := <-clients[0].Send():
+				if msg.Type == MessageError && msg.Code == ErrorEliminated {
+					eliminated = append(eliminated, msg.Turn)
+					continue
+				}
+				clients[0].Receive() <- ClientMessage{StringAction("1")}
+			case msg := <-clients[1].Send():
+				if msg.Type == MessageError {
+					t.Error("Player 2 was eliminated")
+					continue
+				}
+				clients[1].Receive() <- ClientMessage{StringAction("3")}
+			case <-errChan:
+			case <-turnChan:
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	if len(eliminated) != 1 || eliminated[0] != 3 {
+		t.Error("Player 1 was not told it was eliminated once on turn 3")
+	}
+}
+
+// A mock game where the losers are eliminated when the game is over.
+type mockEliminatorState struct {
+	mockState
+}
+
+func (s *mockEliminatorState) Eliminated(p int) bool {
+	return s.Finished() && s.Result()[p] == ResultLoss
+}
@@ -0,0 +1,320 @@
+// Package gametest checks that a game state plays by the rules every game must
+// follow, so that new games get solid tests for free. It plays random games by
+// choosing among the actions the state offers, and checks that:
+//
+//   - every action returned by Actions is accepted when it is committed,
+//   - the state and every view of it can be encoded as JSON,
+//   - the game finishes,
+//   - the result has a win, tie or loss for every player, and every player is
+//     placed,
+//   - teammates share their result, when the game is played in teams,
+//   - playing the same actions from the same seed gives the same states and
+//     result.
+//
+// A game that is registered can be checked with its default settings, e.g.,
+//
+//	func TestConformance(t *testing.T) {
+//		d, err := game.Lookup(Name)
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		gametest.Run(t, gametest.Definition(d))
+//	}
+package gametest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/crestonbunch/botbox/common/game"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+const (
+	// The number of random games played by default.
+	DefaultPlayouts = 20
+	// The number of turns a game may last by default before it is considered
+	// to never finish.
+	DefaultMaxTurns = 10000
+)
+
+// The game to check and how to play it.
+type Config struct {
+	// Create the initial state of a game. All random numbers must be drawn from
+	// the given generator.
+	NewState func(rng *rand.Rand) game.GameState
+	// The number of players in a game.
+	Players int
+	// The number of random games to play. Defaults to DefaultPlayouts.
+	Playouts int
+	// The most turns a game may last. Defaults to DefaultMaxTurns.
+	MaxTurns int
+	// Game i is played with the seed Seed + i.
+	Seed int64
+	// List the actions player p can make as JSON, for games whose Actions do
+	// not encode as a JSON array of them. Nil by default.
+	Moves func(s game.GameState, p int) []json.RawMessage
+	// The teams to play in, for games that can be played in teams. Nil by
+	// default.
+	Teams game.Teams
+}
+
+// Check a registered game with its default settings.
+func Definition(d game.Definition) Config {
+	return Config{
+		Players: d.Players,
+		NewState: func(rng *rand.Rand) game.GameState {
+			var settings interface{}
+			if d.Settings != nil {
+				settings = d.Settings()
+			}
+			return d.NewState(settings, rng)
+		},
+	}
+}
+
+// Run every check as part of a test, and fail the test with the first rule
+// that was broken.
+func Run(t *testing.T, c Config) {
+	t.Helper()
+
+	err := Check(c)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// Play the random games and return the first rule that was broken, or nil if
+// the game plays by the rules.
+func Check(c Config) error {
+	if c.NewState == nil || c.Players < 1 {
+		return errors.New("Config needs a state constructor and players.")
+	}
+	playouts := c.Playouts
+	if playouts <= 0 {
+		playouts = DefaultPlayouts
+	}
+
+	for i := 0; i < playouts; i++ {
+		seed := c.Seed + int64(i)
+		p, err := c.playout(seed)
+		if err == nil {
+			err = c.replay(seed, p)
+		}
+		if err != nil {
+			return errors.New(
+				"Game with seed " + strconv.FormatInt(seed, 10) + ": " + err.Error(),
+			)
+		}
+	}
+
+	return nil
+}
+
+// A record of a random game: the encoded state before the first turn and
+// after every turn, the actions committed during every turn in player order,
+// and the result.
+type playout struct {
+	states  [][]byte
+	actions [][]json.RawMessage
+	result  []int
+}
+
+// Play a random game, checking the state as it goes.
+func (c Config) playout(seed int64) (*playout, error) {
+	maxTurns := c.MaxTurns
+	if maxTurns <= 0 {
+		maxTurns = DefaultMaxTurns
+	}
+	rng := rand.New(rand.NewSource(seed))
+	state, err := c.newState(seed)
+	if err != nil {
+		return nil, err
+	}
+	p := &playout{}
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return nil, errors.New("Initial state can not be encoded: " + err.Error())
+	}
+	p.states = append(p.states, b)
+
+	for turn := 0; !state.Finished(); turn++ {
+		if turn >= maxTurns {
+			return nil, errors.New(
+				"Game did not finish in " + strconv.Itoa(maxTurns) + " turns.",
+			)
+		}
+		prefix := "Turn " + strconv.Itoa(turn) + ": "
+
+		// every player chooses before any action is committed, like the state
+		// managers do
+		players := acting(state, c.Players)
+		actions := make([]json.RawMessage, c.Players)
+		for _, i := range players {
+			_, err := json.Marshal(state.View(i))
+			if err != nil {
+				return nil, errors.New(prefix + "View of player " +
+					strconv.Itoa(i) + " can not be encoded: " + err.Error())
+			}
+			moves, err := c.moves(state, i)
+			if err != nil {
+				return nil, errors.New(prefix + err.Error())
+			}
+			if len(moves) > 0 {
+				actions[i] = moves[rng.Intn(len(moves))]
+			}
+		}
+
+		for _, i := range players {
+			err := commit(state, i, actions[i])
+			if err != nil {
+				return nil, errors.New(prefix + err.Error())
+			}
+		}
+
+		b, err := json.Marshal(state)
+		if err != nil {
+			return nil, errors.New(prefix + "State can not be encoded: " +
+				err.Error())
+		}
+		p.states = append(p.states, b)
+		p.actions = append(p.actions, actions)
+	}
+
+	p.result = state.Result()
+	if len(p.result) != c.Players {
+		return nil, errors.New("Result has " + strconv.Itoa(len(p.result)) +
+			" entries for " + strconv.Itoa(c.Players) + " players.")
+	}
+	for i, r := range p.result {
+		if r != game.ResultWin && r != game.ResultTie && r != game.ResultLoss {
+			return nil, errors.New("Result " + strconv.Itoa(r) + " of player " +
+				strconv.Itoa(i) + " is not a win, tie or loss.")
+		}
+	}
+	places := game.Rank(state)
+	if len(places) != c.Players {
+		return nil, errors.New("Rank has " + strconv.Itoa(len(places)) +
+			" places for " + strconv.Itoa(c.Players) + " players.")
+	}
+	for i, r := range places {
+		if r.Place < 1 || r.Place > c.Players {
+			return nil, errors.New("Player " + strconv.Itoa(i) +
+				" has no place.")
+		}
+	}
+	for i := range c.Teams {
+		for _, j := range c.Teams.Teammates(i) {
+			if p.result[i] != p.result[j] || places[i].Place != places[j].Place {
+				return nil, errors.New("Players " + strconv.Itoa(i) + " and " +
+					strconv.Itoa(j) + " are teammates but do not share a result.")
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// Play the actions of a random game again from the same seed, and check that
+// every state and the result are the same.
+func (c Config) replay(seed int64, p *playout) error {
+	state, err := c.newState(seed)
+	if err != nil {
+		return err
+	}
+	b, _ := json.Marshal(state)
+	if !bytes.Equal(b, p.states[0]) {
+		return errors.New("Initial state is different with the same seed.")
+	}
+
+	for turn, actions := range p.actions {
+		for _, i := range acting(state, c.Players) {
+			commit(state, i, actions[i])
+		}
+		b, _ := json.Marshal(state)
+		if !bytes.Equal(b, p.states[turn+1]) {
+			return errors.New("Turn " + strconv.Itoa(turn) + ": " +
+				"State is different when the same actions are played again.")
+		}
+	}
+
+	if !state.Finished() {
+		return errors.New(
+			"Game did not finish when the same actions were played again.",
+		)
+	}
+	for i, r := range state.Result() {
+		if r != p.result[i] {
+			return errors.New(
+				"Result is different when the same actions are played again.",
+			)
+		}
+	}
+
+	return nil
+}
+
+// Create the initial state of a game from a seed, in teams if there are any.
+func (c Config) newState(seed int64) (game.GameState, error) {
+	state := c.NewState(game.NewRand(seed))
+	err := game.SetTeams(state, c.Players, c.Teams)
+	if err != nil {
+		return nil, errors.New("Teams can not be set: " + err.Error())
+	}
+	return state, nil
+}
+
+// List the actions player p can make.
+func (c Config) moves(s game.GameState, p int) ([]json.RawMessage, error) {
+	if c.Moves != nil {
+		return c.Moves(s, p), nil
+	}
+
+	b, err := json.Marshal(s.Actions(p))
+	if err != nil {
+		return nil, errors.New("Actions of player " + strconv.Itoa(p) +
+			" can not be encoded: " + err.Error())
+	}
+	var moves []json.RawMessage
+	err = json.Unmarshal(b, &moves)
+	if err != nil {
+		return nil, errors.New("Actions of player " + strconv.Itoa(p) +
+			" are not a JSON array, so Config.Moves must list them.")
+	}
+	return moves, nil
+}
+
+// Return the players that act during the next turn: the player whose turn it
+// is in turn-based games, and every player otherwise.
+func acting(s game.GameState, players int) []int {
+	if t, ok := s.(game.TurnBasedGameState); ok {
+		return []int{t.Turn()}
+	}
+
+	all := make([]int, players)
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
+
+// Commit an action like a client sent it. Every action the state offers must
+// be accepted. Players without any actions commit the empty action, which the
+// game may reject, as it would if a client sent nothing.
+func commit(s game.GameState, p int, a json.RawMessage) error {
+	err := game.Commit(s, p, a)
+	switch err.(type) {
+	case nil:
+		return nil
+	case game.MalformedActionError, game.IllegalActionError:
+		if a == nil {
+			return nil
+		}
+		return errors.New("Action " + string(a) + " of player " +
+			strconv.Itoa(p) + " was rejected: " + err.Error())
+	}
+	return err
+}
@@ -0,0 +1,206 @@
+package gametest
+
+import (
+	"encoding/json"
+	"github.com/crestonbunch/botbox/common/game"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// A mock race to a score of 10, where every player adds 1 or 2 to its score
+// each turn. It breaks the rule it is told to.
+type mockState struct {
+	Scores []int `json:"scores"`
+	broken string
+}
+
+func (s *mockState) Actions(p int) interface{} {
+	if s.broken == "actions" {
+		return map[string]string{"add": "1"}
+	}
+	return []string{"1", "2"}
+}
+
+func (s *mockState) Do(p int, a string) {
+	switch {
+	case s.broken == "finish":
+	case s.broken == "random":
+		s.Scores[p] += 1 + rand.Intn(2)
+	case a == "2":
+		s.Scores[p] += 2
+	default:
+		s.Scores[p]++
+	}
+}
+
+func (s *mockState) Validate(p int, a string) bool {
+	return s.broken != "reject" || a != "2"
+}
+
+func (s *mockState) View(p int) interface{} {
+	if s.broken == "view" {
+		return make(chan int)
+	}
+	return s
+}
+
+func (s *mockState) Finished() bool {
+	for _, score := range s.Scores {
+		if score >= 10 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *mockState) Result() []int {
+	if s.broken == "result" {
+		return []int{game.ResultWin}
+	}
+
+	best := 0
+	for _, score := range s.Scores {
+		if score > best {
+			best = score
+		}
+	}
+	result := make([]int, len(s.Scores))
+	for i, score := range s.Scores {
+		if score < best {
+			result[i] = game.ResultLoss
+		}
+	}
+	return result
+}
+
+// The same race, but players take turns.
+type mockTurnBasedState struct {
+	mockState
+	Next int `json:"next"`
+}
+
+func (s *mockTurnBasedState) Do(p int, a string) {
+	s.mockState.Do(p, a)
+	s.Next = (s.Next + 1) % len(s.Scores)
+}
+
+func (s *mockTurnBasedState) Turn() int {
+	return s.Next
+}
+
+// The same race played in teams, where a team wins if any of its players
+// reaches 10 first.
+type mockTeamState struct {
+	mockState
+	game.TeamPlay
+}
+
+func (s *mockTeamState) Result() []int {
+	teams := s.Teams()
+	result := s.mockState.Result()
+	if s.broken == "team" {
+		return result
+	}
+
+	teamResults := make([]int, len(teams.Ids()))
+	for i := range teamResults {
+		teamResults[i] = game.ResultLoss
+	}
+	for p, r := range result {
+		if r != game.ResultLoss {
+			teamResults[teams.Team(p)] = game.ResultWin
+		}
+	}
+	return teams.Result(teamResults)
+}
+
+func mockTeamConfig(broken string) Config {
+	return Config{
+		Players: 4,
+		Teams:   game.Teams{"red", "blue", "red", "blue"},
+		NewState: func(rng *rand.Rand) game.GameState {
+			return &mockTeamState{mockState: mockState{make([]int, 4), broken}}
+		},
+	}
+}
+
+func mockConfig(broken string) Config {
+	return Config{
+		Players: 3,
+		NewState: func(rng *rand.Rand) game.GameState {
+			return &mockState{make([]int, 3), broken}
+		},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	Run(t, mockConfig(""))
+
+	c := mockConfig("")
+	c.NewState = func(rng *rand.Rand) game.GameState {
+		return &mockTurnBasedState{mockState{make([]int, 2), ""}, rng.Intn(2)}
+	}
+	c.Players = 2
+	Run(t, c)
+}
+
+func TestCheckBroken(t *testing.T) {
+	for broken, want := range map[string]string{
+		"actions": "Config.Moves",
+		"finish":  "did not finish",
+		"random":  "different",
+		"reject":  "was rejected",
+		"result":  "entries for 3 players",
+		"view":    "can not be encoded",
+	} {
+		err := Check(mockConfig(broken))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Error("Check did not catch a game that breaks ", broken, ": ", err)
+		}
+	}
+
+	c := mockConfig("")
+	c.NewState = func(rng *rand.Rand) game.GameState {
+		return &mockState{[]int{rand.Intn(5), 0, 0}, ""}
+	}
+	err := Check(c)
+	if err == nil || !strings.Contains(err.Error(), "Initial state") {
+		t.Error("Check did not catch a game that ignores its seed: ", err)
+	}
+}
+
+func TestCheckTeams(t *testing.T) {
+	Run(t, mockTeamConfig(""))
+
+	err := Check(mockTeamConfig("team"))
+	if err == nil || !strings.Contains(err.Error(), "teammates") {
+		t.Error("Check did not catch teammates with different results: ", err)
+	}
+
+	c := mockConfig("")
+	c.Teams = game.Teams{"red", "blue", "red"}
+	err = Check(c)
+	if err == nil || !strings.Contains(err.Error(), "Teams can not be set") {
+		t.Error("Check played teams in a game without them: ", err)
+	}
+}
+
+func TestCheckMoves(t *testing.T) {
+	c := mockConfig("actions")
+	c.Moves = func(s game.GameState, p int) []json.RawMessage {
+		return []json.RawMessage{game.StringAction("1")}
+	}
+	Run(t, c)
+}
+
+func TestDefinition(t *testing.T) {
+	d := game.Definition{
+		Name:    "race",
+		Players: 2,
+		NewState: func(settings interface{}, rng *rand.Rand) game.GameState {
+			return &mockState{make([]int, 2), ""}
+		},
+	}
+	Run(t, Definition(d))
+}
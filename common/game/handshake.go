@@ -0,0 +1,109 @@
+package game
+
+import (
+	"errors"
+	"golang.org/x/net/websocket"
+	"strings"
+	"time"
+)
+
+// The version of the wire protocol spoken by the server. Clients must speak
+// the same major version to be accepted.
+const ProtocolVersion = "1.0.0"
+
+// The first message sent to a client after it is accepted, so it can learn
+// about the game before the first state arrives.
+type HelloMessage struct {
+	Type     string      `json:"type"`
+	Version  string      `json:"version"`
+	Game     string      `json:"game"`
+	Player   int         `json:"player"`
+	Players  int         `json:"players"`
+	Time     ClockStatus `json:"time"`
+	Settings interface{} `json:"settings,omitempty"`
+	// The other players on the team of the player, in matches played in teams.
+	Teammates []int `json:"teammates,omitempty"`
+}
+
+// The reply a client sends to the hello message. The version is the protocol
+// version the SDK speaks, not the version of the SDK itself.
+type ClientHello struct {
+	SDK        string `json:"sdk"`
+	SDKVersion string `json:"sdk_version,omitempty"`
+	Version    string `json:"version"`
+}
+
+// A handshake describes the game to clients when they connect and checks that
+// they speak a compatible version of the protocol.
+type Handshake struct {
+	Game     string
+	Time     TimeControl
+	Settings interface{}
+	// The teams of the match, if it is played in teams.
+	Teams Teams
+	// How long a client has to reply to the hello message.
+	Timeout time.Duration
+}
+
+// Send the hello message to a client and wait for its reply. If the client
+// does not reply in time or speaks an incompatible protocol, then it is sent
+// an error message and an error is returned.
+func (h *Handshake) Shake(
+	conn *websocket.Conn, player, players int,
+) (*ClientHello, error) {
+	hello := HelloMessage{
+		Type:     MessageHello,
+		Version:  ProtocolVersion,
+		Game:     h.Game,
+		Player:   player,
+		Players:  players,
+		Time:     h.Time.Status(),
+		Settings: h.Settings,
+	}
+	if h.Teams != nil {
+		hello.Teammates = h.Teams.Teammates(player)
+	}
+
+	conn.SetDeadline(time.Now().Add(h.Timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	codec := ConnCodec(conn)
+	err := codec.Send(conn, &hello)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &ClientHello{}
+	err = codec.Receive(conn, reply)
+	if err != nil {
+		h.reject(conn, ErrorBadHandshake, "Expected a hello reply.")
+		return nil, errors.New("Client did not reply to hello: " + err.Error())
+	}
+	if reply.Version == "" {
+		h.reject(conn, ErrorBadHandshake, "Hello reply is missing a version.")
+		return nil, errors.New("Client did not send a protocol version.")
+	}
+	if majorVersion(reply.Version) != majorVersion(ProtocolVersion) {
+		msg := "Client speaks protocol " + reply.Version +
+			" but the server speaks " + ProtocolVersion + "."
+		h.reject(conn, ErrorVersionMismatch, msg)
+		return nil, errors.New(msg)
+	}
+
+	return reply, nil
+}
+
+// Tell a client why it was rejected. The deadline of the handshake may be over
+// already, so the client is given another timeout to read the error.
+func (h *Handshake) reject(conn *websocket.Conn, code, msg string) {
+	conn.SetWriteDeadline(time.Now().Add(h.Timeout))
+	ConnCodec(conn).Send(conn, &ServerMessage{
+		Type:    MessageError,
+		Code:    code,
+		Message: msg,
+	})
+}
+
+func majorVersion(v string) string {
+	return strings.SplitN(strings.TrimPrefix(v, "v"), ".", 2)[0]
+}
@@ -0,0 +1,288 @@
+package game
+
+import (
+	"context"
+	"golang.org/x/net/websocket"
+	"testing"
+	"time"
+)
+
+func TestHandshake(t *testing.T) {
+	connChan := make(chan *websocket.Conn)
+	done := make(chan bool)
+	ids := []string{"id1", "id2"}
+	secrets := []string{"secret1", "secret2"}
+	m := NewAuthenticatedClientManager(
+		func(id string, conn *websocket.Conn) GameClient {
+			return nil
+		},
+		ids,
+		secrets,
+		ConnTimeout,
+	)
+	m.SetHandshake(&Handshake{
+		Game:     "mock",
+		Time:     TimeControl{Bank: time.Second, Move: 100 * time.Millisecond},
+		Settings: map[string]int{"width": 10},
+		Timeout:  time.Second,
+	})
+
+	wg := m.Register(context.Background(), connChan)
+
+	url, ts := setupTestServer(websocket.Handler(func(conn *websocket.Conn) {
+		connChan <- conn
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+	origin := "http://localhost/"
+
+	for i := 0; i < 2; i++ {
+		config, err := websocket.NewConfig(url, origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Header.Add("Authorization", secrets[i])
+		conn, err := websocket.DialConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		var hello HelloMessage
+		err = websocket.JSON.Receive(conn, &hello)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hello.Type != MessageHello || hello.Version != ProtocolVersion {
+			t.Error("Hello message has the wrong type or version")
+		}
+		if hello.Game != "mock" || hello.Player != i || hello.Players != 2 {
+			t.Error("Hello message does not describe the game")
+		}
+		if hello.Time.Remaining != 1000 || hello.Time.Move != 100 {
+			t.Error("Hello message has the wrong time controls")
+		}
+
+		reply := ClientHello{SDK: "test", SDKVersion: "0.1", Version: "1.2.0"}
+		err = websocket.JSON.Send(conn, &reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wg.Wait()
+
+	if !m.Ready() {
+		t.Error("Client manager did not accept clients with a minor version")
+	}
+}
+
+func TestHandshakeIdOrder(t *testing.T) {
+	connChan := make(chan *websocket.Conn)
+	done := make(chan bool)
+	ids := []string{"id1", "id2", "id3"}
+	secrets := []string{"secret1", "secret2", "secret3"}
+	m := NewAuthenticatedClientManager(
+		func(id string, conn *websocket.Conn) GameClient {
+			return NewSynchronizedGameClient(id, conn, time.Second, TimeControl{})
+		},
+		ids,
+		secrets,
+		ConnTimeout,
+	)
+	m.SetHandshake(&Handshake{Game: "mock", Timeout: time.Second})
+
+	wg := m.Register(context.Background(), connChan)
+
+	url, ts := setupTestServer(websocket.Handler(func(conn *websocket.Conn) {
+		connChan <- conn
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+	origin := "http://localhost/"
+
+	// clients connect in the reverse order of their ids, but still play as the
+	// player of their id
+	for i := len(ids) - 1; i >= 0; i-- {
+		config, err := websocket.NewConfig(url, origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Header.Add("Authorization", secrets[i])
+		conn, err := websocket.DialConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		var hello HelloMessage
+		err = websocket.JSON.Receive(conn, &hello)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hello.Player != i {
+			t.Error("Client ", ids[i], " was greeted as player ", hello.Player)
+		}
+
+		reply := ClientHello{SDK: "test", SDKVersion: "0.1", Version: "1.0.0"}
+		err = websocket.JSON.Send(conn, &reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wg.Wait()
+
+	if !m.Ready() {
+		t.Fatal("Client manager did not accept every client")
+	}
+	for i, c := range m.Clients() {
+		if c.Id() != ids[i] {
+			t.Error("Client ", c.Id(), " plays as player ", i)
+		}
+	}
+}
+
+func TestHandshakeVersionMismatch(t *testing.T) {
+	connChan := make(chan *websocket.Conn)
+	done := make(chan bool)
+	ids := []string{"id1"}
+	secrets := []string{"secret1"}
+	m := NewAuthenticatedClientManager(
+		func(id string, conn *websocket.Conn) GameClient {
+			return nil
+		},
+		ids,
+		secrets,
+		100*time.Millisecond,
+	)
+	m.SetHandshake(&Handshake{Game: "mock", Timeout: time.Second})
+
+	wg := m.Register(context.Background(), connChan)
+
+	url, ts := setupTestServer(websocket.Handler(func(conn *websocket.Conn) {
+		connChan <- conn
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+	origin := "http://localhost/"
+
+	config, err := websocket.NewConfig(url, origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Header.Add("Authorization", secrets[0])
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var hello HelloMessage
+	err = websocket.JSON.Receive(conn, &hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := ClientHello{SDK: "test", Version: "2.0.0"}
+	err = websocket.JSON.Send(conn, &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg ServerMessage
+	err = websocket.JSON.Receive(conn, &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != MessageError || msg.Code != ErrorVersionMismatch {
+		t.Error("Client was not told its version does not match")
+	}
+
+	wg.Wait()
+
+	if m.Ready() {
+		t.Error("Client manager accepted a client with a different major version")
+	}
+}
+
+func TestHandshakeRetry(t *testing.T) {
+	connChan := make(chan *websocket.Conn)
+	done := make(chan bool)
+	ids := []string{"id1", "id2"}
+	secrets := []string{"secret1", "secret2"}
+	m := NewAuthenticatedClientManager(
+		func(id string, conn *websocket.Conn) GameClient {
+			return nil
+		},
+		ids,
+		secrets,
+		ConnTimeout,
+	)
+	m.SetHandshake(&Handshake{Game: "mock", Timeout: time.Second})
+
+	wg := m.Register(context.Background(), connChan)
+
+	url, ts := setupTestServer(websocket.Handler(func(conn *websocket.Conn) {
+		connChan <- conn
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+	dial := func(secret string) (*websocket.Conn, HelloMessage) {
+		config, err := websocket.NewConfig(url, "http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Header.Add("Authorization", secret)
+		conn, err := websocket.DialConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hello HelloMessage
+		err = websocket.JSON.Receive(conn, &hello)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, hello
+	}
+
+	// the first client takes its time, which does not hold up the second
+	slow, hello := dial(secrets[0])
+	defer slow.Close()
+	if hello.Player != 0 {
+		t.Error("Slow client is not player 1")
+	}
+	conn, hello := dial(secrets[1])
+	defer conn.Close()
+	if hello.Player != 1 {
+		t.Error("Second client is not player 2")
+	}
+	websocket.JSON.Send(conn, &ClientHello{SDK: "test", Version: "1.0.0"})
+
+	// the first client fails the handshake, and tries again with its secret
+	websocket.JSON.Send(slow, &ClientHello{SDK: "test", Version: "2.0.0"})
+	var msg ServerMessage
+	err := websocket.JSON.Receive(slow, &msg)
+	if err != nil || msg.Code != ErrorVersionMismatch {
+		t.Error("Client was not told its version does not match")
+	}
+	// wait until the server hangs up
+	if websocket.JSON.Receive(slow, &msg) == nil {
+		t.Error("Client that failed the handshake was not disconnected")
+	}
+	retry, hello := dial(secrets[0])
+	defer retry.Close()
+	if hello.Player != 0 {
+		t.Error("Client that tried again is not player 1")
+	}
+	websocket.JSON.Send(retry, &ClientHello{SDK: "test", Version: "1.0.0"})
+
+	wg.Wait()
+
+	if !m.Ready() {
+		t.Error("Client that failed the handshake could not try again")
+	}
+}
@@ -0,0 +1,128 @@
+package game
+
+import (
+	"encoding/json"
+	"github.com/crestonbunch/botbox/common/game/replay"
+	"time"
+)
+
+// The longest a match can last by default, so that a game that never finishes
+// does not run forever.
+const MatchTimeout = time.Hour
+
+// The reasons a match is stopped before the game is finished.
+const (
+	AdjudicatedTurns    = "turn limit reached"
+	AdjudicatedDuration = "time limit reached"
+)
+
+// Limits on the length of a match. The state manager stops the match between
+// turns once it reaches a limit, and the result is adjudicated. Zero means no
+// limit.
+type Limits struct {
+	// The most turns a match can last.
+	Turns int
+	// The longest a match can last once it has started. Turns that are in
+	// progress when the time runs out are played to the end.
+	Duration time.Duration
+}
+
+// The default limits of state managers.
+func DefaultLimits() Limits {
+	return Limits{Duration: MatchTimeout}
+}
+
+// Check the limits after a turn is played. If the game is not finished, but a
+// limit is reached, then return the adjudicated state and true. Otherwise
+// return the state as it is.
+func (l Limits) check(
+	s GameState, players, turn int, started time.Time,
+) (GameState, bool) {
+	if s.Finished() {
+		return s, false
+	}
+	if l.Turns > 0 && turn+1 >= l.Turns {
+		return Adjudicate(s, players, AdjudicatedTurns), true
+	}
+	if l.Duration > 0 && time.Since(started) >= l.Duration {
+		return Adjudicate(s, players, AdjudicatedDuration), true
+	}
+	return s, false
+}
+
+// Games can decide the result of a match that was stopped before it finished,
+// e.g., by giving the win to the player that is ahead. Otherwise the match is
+// a draw.
+type Adjudicator interface {
+	Adjudicate() []replay.Result
+}
+
+// The state of a game that was stopped before it finished. It is finished, and
+// its result is decided by the game if it is an adjudicator. The state is
+// encoded as JSON the same way as the game.
+type AdjudicatedState struct {
+	GameState
+	// Why the match was stopped.
+	Reason  string
+	results []replay.Result
+}
+
+// Stop a game between the given number of players and decide its result.
+// Players that the game did not give a reason for their result get the reason
+// the match was stopped.
+func Adjudicate(s GameState, players int, reason string) *AdjudicatedState {
+	var results []replay.Result
+	if a, ok := s.(Adjudicator); ok {
+		results = a.Adjudicate()
+	} else {
+		results = replay.Places(make([]int, players))
+	}
+	results = teamsOf(s).label(results)
+	for i := range results {
+		if results[i].Reason == "" {
+			results[i].Reason = reason
+		}
+	}
+
+	return &AdjudicatedState{s, reason, results}
+}
+
+func (s *AdjudicatedState) Finished() bool {
+	return true
+}
+
+// Players that finished first on their own or with only their teammates win,
+// players that share first place with others tie, and everyone else loses.
+func (s *AdjudicatedState) Result() []int {
+	first := 0
+	teams := map[string]bool{}
+	for _, r := range s.results {
+		if r.Place != 1 || teams[r.Team] {
+			continue
+		}
+		if r.Team != "" {
+			teams[r.Team] = true
+		}
+		first++
+	}
+
+	result := make([]int, len(s.results))
+	for i, r := range s.results {
+		if r.Place != 1 {
+			result[i] = ResultLoss
+		} else if first == 1 {
+			result[i] = ResultWin
+		} else {
+			result[i] = ResultTie
+		}
+	}
+	return result
+}
+
+func (s *AdjudicatedState) Rank() []replay.Result {
+	return s.results
+}
+
+func (s *AdjudicatedState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.GameState)
+}
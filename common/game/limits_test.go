@@ -0,0 +1,81 @@
+package game
+
+import (
+	"encoding/json"
+	"github.com/crestonbunch/botbox/common/game/replay"
+	"testing"
+	"time"
+)
+
+// A mock game that gives the win to whoever is ahead when it is stopped.
+type mockAdjudicatorState struct {
+	mockState
+}
+
+func (s *mockAdjudicatorState) Adjudicate() []replay.Result {
+	return replay.Places(s.mockState.Result())
+}
+
+func TestAdjudicate(t *testing.T) {
+	state := &mockState{[]int{4, 2}}
+	a := Adjudicate(state, 2, AdjudicatedTurns)
+	if !a.Finished() || a.Reason != AdjudicatedTurns {
+		t.Error("Adjudicated game is not finished")
+	}
+	result := a.Result()
+	if result[0] != ResultTie || result[1] != ResultTie {
+		t.Error("Game without an adjudicator was not a draw")
+	}
+	results := Rank(a)
+	if results[0].Place != 1 || results[1].Reason != AdjudicatedTurns {
+		t.Error("Drawn players were not placed with the reason")
+	}
+	b, _ := json.Marshal(a)
+	if string(b) != `{"players":[4,2]}` {
+		t.Error("Adjudicated state was not encoded like the game: " + string(b))
+	}
+
+	a = Adjudicate(&mockAdjudicatorState{mockState{[]int{4, 2}}}, 2, "stopped")
+	result = a.Result()
+	if result[0] != ResultWin || result[1] != ResultLoss {
+		t.Error("Adjudicator did not decide the result")
+	}
+	if Rank(a)[1].Place != 2 || Rank(a)[1].Reason != "stopped" {
+		t.Error("Adjudicator did not place the players")
+	}
+
+	a = Adjudicate(&mockAdjudicatorState{mockState{[]int{4, 4, 1}}}, 3, "stopped")
+	result = a.Result()
+	if result[0] != ResultTie || result[1] != ResultTie || result[2] != ResultLoss {
+		t.Error("Players sharing first place did not tie")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	state := &mockState{[]int{0, 0}}
+	limits := Limits{Turns: 3}
+	if _, stop := limits.check(state, 2, 1, time.Now()); stop {
+		t.Error("Game was stopped before the turn limit")
+	}
+	s, stop := limits.check(state, 2, 2, time.Now())
+	if a, ok := s.(*AdjudicatedState); !stop || !ok || a.Reason != AdjudicatedTurns {
+		t.Error("Game was not stopped at the turn limit")
+	}
+
+	limits = Limits{Duration: time.Second}
+	if _, stop := limits.check(state, 2, 100, time.Now()); stop {
+		t.Error("Game was stopped before the time limit")
+	}
+	s, stop = limits.check(state, 2, 0, time.Now().Add(-time.Second))
+	if a, ok := s.(*AdjudicatedState); !stop || !ok || a.Reason != AdjudicatedDuration {
+		t.Error("Game was not stopped at the time limit")
+	}
+
+	state = &mockState{[]int{10, 0}}
+	if s, stop := limits.check(state, 2, 0, time.Time{}); stop || s != state {
+		t.Error("Finished game was adjudicated")
+	}
+	if _, stop := (Limits{}).check(&mockState{[]int{0, 0}}, 2, 1000, time.Time{}); stop {
+		t.Error("Game without limits was stopped")
+	}
+}
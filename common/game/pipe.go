@@ -0,0 +1,174 @@
+package game
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"golang.org/x/net/websocket"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// A client that plays over a pair of pipes instead of a websocket, usually the
+// stdin and stdout of a bot process, so that bots do not need a websocket
+// library. Every message is a single line of JSON in both directions, with the
+// same contents as a websocket message. The client wraps a client made by a
+// state manager, so that it is played with the same time controls. Pipes can
+// not be reconnected.
+type PipeGameClient struct {
+	GameClient
+	transport *pipeTransport
+}
+
+// Attach a client to the output and input of a bot.
+func NewPipeGameClient(
+	c GameClient, r io.ReadCloser, w io.WriteCloser,
+) *PipeGameClient {
+	return &PipeGameClient{c, newPipeTransport(r, w)}
+}
+
+// Start a bot process and attach a client to its stdin and stdout. The caller
+// waits for the process to exit once the game is over.
+func StartPipeGameClient(c GameClient, cmd *exec.Cmd) (*PipeGameClient, error) {
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPipeGameClient(c, r, w), nil
+}
+
+// Listen to the pipes like Listen does to a websocket. The pipes are closed
+// when the context is done, which stops the listener.
+func (c *PipeGameClient) Listen(ctx context.Context) *sync.WaitGroup {
+	wg := listenOn(ctx, c, c.transport)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		c.transport.close()
+	}()
+
+	return wg
+}
+
+// Close the pipes.
+func (c *PipeGameClient) Close() error {
+	return c.transport.close()
+}
+
+// Pipes have no websocket connection.
+func (c *PipeGameClient) Conn() *websocket.Conn {
+	return nil
+}
+
+// Pipes can not be reconnected, so this does nothing.
+func (c *PipeGameClient) Reconnect(conn *websocket.Conn) {
+}
+
+// Newline-delimited JSON over a pair of pipes.
+type pipeTransport struct {
+	r       io.ReadCloser
+	w       io.WriteCloser
+	scanner *bufio.Scanner
+	// errors are sent from the receiving goroutine, so writes must not overlap
+	mutex sync.Mutex
+	once  sync.Once
+}
+
+func newPipeTransport(r io.ReadCloser, w io.WriteCloser) *pipeTransport {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), MaxMessageSize)
+	return &pipeTransport{r: r, w: w, scanner: scanner}
+}
+
+func (t *pipeTransport) send(msg *ServerMessage) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	_, err = t.w.Write(append(b, '\n'))
+	return err
+}
+
+// Read the next line that is not blank.
+func (t *pipeTransport) receive(msg *ClientMessage) error {
+	for t.scanner.Scan() {
+		line := bytes.TrimSpace(t.scanner.Bytes())
+		if len(line) > 0 {
+			return json.Unmarshal(line, msg)
+		}
+	}
+
+	err := t.scanner.Err()
+	if err == bufio.ErrTooLong {
+		return errMessageTooLarge
+	} else if err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func (t *pipeTransport) close() error {
+	var err error
+	t.once.Do(func() {
+		err = t.w.Close()
+		if e := t.r.Close(); err == nil {
+			err = e
+		}
+	})
+	return err
+}
+
+// A client manager for bots attached over pipes. Every client is connected
+// from the start, so there is nothing to wait for, and clients that disconnect
+// are gone for good.
+type PipeClientManager struct {
+	clients       []GameClient
+	reconnections chan GameClient
+}
+
+// Create a new client manager for clients in player order.
+func NewPipeClientManager(clients ...GameClient) *PipeClientManager {
+	return &PipeClientManager{clients, make(chan GameClient)}
+}
+
+// Every client is already connected, so websocket connections are ignored.
+func (m *PipeClientManager) Register(
+	ctx context.Context, connChan chan *websocket.Conn,
+) *sync.WaitGroup {
+	return &sync.WaitGroup{}
+}
+
+func (m *PipeClientManager) Ready() bool {
+	return true
+}
+
+func (m *PipeClientManager) Clients() []GameClient {
+	return m.clients
+}
+
+func (m *PipeClientManager) Disconnected(c GameClient) {
+}
+
+// The pipes of a bot that broke a rule are already closed by its listener.
+func (m *PipeClientManager) Banned(c GameClient) {
+}
+
+// Never receives anything, since pipes can not be reconnected.
+func (m *PipeClientManager) Reconnections() chan GameClient {
+	return m.reconnections
+}
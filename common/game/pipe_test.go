@@ -0,0 +1,128 @@
+package game
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Attach a client to a bot that answers every state message with the given
+// action over a pair of in-memory pipes, or never answers if it is empty.
+func pipeBot(c GameClient, action string) *PipeGameClient {
+	toBot, fromServer := io.Pipe()
+	fromBot, toServer := io.Pipe()
+
+	go func() {
+		scanner := bufio.NewScanner(toBot)
+		for scanner.Scan() {
+			var msg ServerMessage
+			json.Unmarshal(scanner.Bytes(), &msg)
+			if msg.Type != MessageState || action == "" {
+				continue
+			}
+			b, _ := json.Marshal(ClientMessage{StringAction(action)})
+			toServer.Write(append(b, '\n'))
+		}
+	}()
+
+	return NewPipeGameClient(c, fromBot, fromServer)
+}
+
+// Start a mock game between two bots over pipes.
+func startPipeGame(
+	ctx context.Context, timeout time.Duration, actions []string,
+) (*mockState, <-chan struct{}) {
+	state := mockTwoPlayerGame()
+	stateMan := NewSynchronizedStateManager(state, timeout)
+	clients := []GameClient{}
+	for i, action := range actions {
+		c := stateMan.NewClient("id"+strconv.Itoa(i+1), nil)
+		clients = append(clients, pipeBot(c, action))
+	}
+
+	_, done := GameHandler(
+		ctx,
+		NewSimpleConnectionManager(),
+		NewPipeClientManager(clients...),
+		stateMan,
+		&mockGameRecorder{},
+	)
+	return state, done
+}
+
+func TestPipeGame(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	state, done := startPipeGame(
+		context.Background(), time.Second, []string{"1", "3"},
+	)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Game did not finish.")
+	}
+
+	if !state.Finished() || state.Result()[1] != ResultWin {
+		t.Error("Game was not played over the pipes")
+	}
+	checkGoroutines(t, before)
+}
+
+func TestPipeGameCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// the bots never answer, so the game would wait on them for a minute
+	_, done := startPipeGame(ctx, time.Minute, []string{"", ""})
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Cancelled game did not shut down.")
+	}
+
+	checkGoroutines(t, before)
+}
+
+func TestPipeMessageTooLarge(t *testing.T) {
+	toBot, fromServer := io.Pipe()
+	fromBot, toServer := io.Pipe()
+	c := NewPipeGameClient(
+		NewSynchronizedGameClient("1", nil, time.Second, FixedTimeControl(time.Second)),
+		fromBot, fromServer,
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg := Listen(ctx, c)
+
+	go toServer.Write([]byte(strings.Repeat("a", MaxMessageSize+1) + "\n"))
+	var msg ServerMessage
+	line, _ := bufio.NewReader(toBot).ReadBytes('\n')
+	json.Unmarshal(line, &msg)
+	if msg.Type != MessageError || msg.Code != ErrorMessageTooLarge {
+		t.Error("Bot was not told why it was disconnected")
+	}
+
+	select {
+	case err := <-c.Error():
+		v, ok := err.err.(ViolationError)
+		if !ok || v.Code != ErrorMessageTooLarge || err.client != c {
+			t.Error("Large message was not a violation: " + err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Bot was not disconnected")
+	}
+
+	if _, err := toBot.Read(make([]byte, 1)); err != io.EOF {
+		t.Error("Pipes were not closed")
+	}
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,150 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"golang.org/x/net/websocket"
+	"log"
+	"sync"
+	"time"
+)
+
+const TickRate = 100 * time.Millisecond
+
+// Game states may implement this interface to decide what action is taken
+// for a player that did not send anything during a tick in a real-time game.
+// Otherwise the empty action is committed.
+type DefaultActor interface {
+	Default(p int) string
+}
+
+// Real-time games advance on a fixed tick whether or not the clients have
+// responded, so a slow client only slows itself down.
+type RealTimeStateManager struct {
+	state  GameState
+	tick   time.Duration
+	limits Limits
+}
+
+// Create a new real-time state manager that advances the game state once
+// every tick.
+func NewRealTimeStateManager(
+	game GameState, tick time.Duration,
+) *RealTimeStateManager {
+	return &RealTimeStateManager{game, tick, DefaultLimits()}
+}
+
+// Stop the match at the given limits instead of the default ones. Every tick
+// is a turn.
+func (m *RealTimeStateManager) SetLimits(limits Limits) {
+	m.limits = limits
+}
+
+func (m *RealTimeStateManager) NewClient(
+	id string, conn *websocket.Conn,
+) GameClient {
+	return NewSynchronizedGameClient(id, conn, m.tick, FixedTimeControl(m.tick))
+}
+
+// Send the state to every client at the start of each tick, and commit the
+// last action each client sent during the tick when it ends. Clients that are
+// still busy receiving the previous state are skipped. Clients that did not
+// send anything during the tick get the default action of the game state.
+// Stops at the end of a tick when the context is done, or when a limit is
+// reached, in which case the last turn holds the adjudicated state.
+func (m *RealTimeStateManager) Play(
+	ctx context.Context,
+	clients []GameClient,
+	turnChan chan Turn,
+	errChan chan error,
+) *sync.WaitGroup {
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	var mutex sync.Mutex
+	latest := make([]*ClientMessage, len(clients))
+	arrived := make([]time.Time, len(clients))
+	started := time.Now()
+	done := make(chan bool)
+
+	// collect messages from each client as they arrive, only keeping the most
+	// recent message sent during a tick
+	for i, c := range clients {
+		go func(i int, c GameClient) {
+			for {
+				select {
+				case msg := <-c.Receive():
+					mutex.Lock()
+					latest[i] = &msg
+					arrived[i] = time.Now()
+					mutex.Unlock()
+				case <-done:
+					return
+				}
+			}
+		}(i, c)
+	}
+
+	go func() {
+		ticker := time.NewTicker(m.tick)
+		defer ticker.Stop()
+
+		eliminated := make([]bool, len(clients))
+		begun := time.Now()
+		for turn := 0; !m.state.Finished() && ctx.Err() == nil; turn++ {
+			mutex.Lock()
+			started = time.Now()
+			mutex.Unlock()
+			for i, c := range clients {
+				// never block the tick waiting on a slow client
+				select {
+				case c.Send() <- stateMessage(m.state, c, turn, i):
+				default:
+					log.Println("Client " + c.Id() + " is not ready, skipping")
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				continue
+			}
+
+			mutex.Lock()
+			actions := make([]json.RawMessage, len(clients))
+			times := make([]time.Duration, len(clients))
+			for i, msg := range latest {
+				if msg != nil {
+					actions[i] = msg.Action
+					if arrived[i].After(started) {
+						times[i] = arrived[i].Sub(started)
+					}
+				} else if d, ok := m.state.(DefaultActor); ok {
+					actions[i] = StringAction(d.Default(i))
+				}
+				latest[i] = nil
+			}
+			mutex.Unlock()
+
+			// commit actions simultaneously
+			for i, a := range actions {
+				commit(ctx, m.state, i, clients[i], a, turn, errChan)
+			}
+			notifyEliminated(m.state, clients, turn, eliminated)
+			state, stop := m.limits.check(m.state, len(clients), turn, begun)
+			select {
+			case turnChan <- takeTurn(turn, actions, times, state):
+			case <-ctx.Done():
+			}
+			if stop {
+				break
+			}
+		}
+
+		close(done)
+		wg.Done()
+	}()
+
+	return &wg
+}
@@ -0,0 +1,168 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"golang.org/x/net/websocket"
+	"testing"
+	"time"
+)
+
+func TestRealTimeStateManager(t *testing.T) {
+	state := &mockState{[]int{0, 0}}
+	turnChan := make(chan Turn)
+	errChan := make(chan error)
+	stateMan := NewRealTimeStateManager(state, 5*time.Millisecond)
+
+	clients := []GameClient{
+		stateMan.NewClient("1", nil),
+		stateMan.NewClient("2", nil),
+	}
+
+	// Player 1 sends a few actions per tick, only the last one counts. Player 2
+	// never reads the state, but its actions should still be committed.
+	go func() {
+		for {
+			select {
+			case <-clients[0].Send():
+				clients[0].Receive() <- ClientMessage{StringAction("3")}
+				clients[0].Receive() <- ClientMessage{StringAction("1")}
+			case <-turnChan:
+				clients[1].Receive() <- ClientMessage{StringAction("3")}
+			}
+		}
+	}()
+
+	wg := stateMan.Play(context.Background(), clients, turnChan, errChan)
+	wg.Wait()
+
+	if !state.Finished() {
+		t.Error("Game did not finish!")
+	}
+
+	result := state.Result()
+
+	if result[0] != ResultLoss {
+		t.Error("Player 1 did not lose")
+	}
+	if result[1] != ResultWin {
+		t.Error("Player 2 did not win")
+	}
+}
+
+func TestRealTimeDefaultAction(t *testing.T) {
+	state := &mockDefaultState{mockState{[]int{0, 0}}}
+	turnChan := make(chan Turn)
+	errChan := make(chan error)
+	stateMan := NewRealTimeStateManager(state, time.Millisecond)
+
+	clients := []GameClient{
+		stateMan.NewClient("1", nil),
+		stateMan.NewClient("2", nil),
+	}
+
+	// Neither player ever responds
+	go func() {
+		for {
+			<-turnChan
+		}
+	}()
+
+	wg := stateMan.Play(context.Background(), clients, turnChan, errChan)
+	wg.Wait()
+
+	if state.Players[0] != 10 {
+		t.Error("Player 1 score is not 10")
+	}
+	if state.Players[1] != 10 {
+		t.Error("Player 2 score is not 10")
+	}
+}
+
+func TestRealTimeView(t *testing.T) {
+	state := &mockState{[]int{0, 0}}
+	turnChan := make(chan Turn)
+	stateMan := NewRealTimeStateManager(state, 50*time.Millisecond)
+
+	clients := []GameClient{
+		stateMan.NewClient("1", nil),
+		stateMan.NewClient("2", nil),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := stateMan.Play(ctx, clients, turnChan, make(chan error))
+
+	// the state changes at the end of the tick, while the message that was sent
+	// at the start of it may still be on its way
+	msg := <-clients[0].Send()
+	clients[0].Receive() <- ClientMessage{StringAction("3")}
+	<-turnChan
+	cancel()
+	wg.Wait()
+
+	if state.Players[0] != 3 {
+		t.Fatal("Action was not committed")
+	}
+	b, err := json.Marshal(msg.State)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"players":[0,0]}` {
+		t.Error("Message does not hold the view it was sent with: " + string(b))
+	}
+}
+
+func TestRealTimeGameHandler(t *testing.T) {
+	ids := []string{"id1", "id2"}
+	secrets := []string{"secret1", "secret2"}
+
+	connMan := NewSimpleConnectionManager()
+	state := &mockDefaultState{mockState{[]int{0, 0}}}
+	stateMan := NewRealTimeStateManager(state, time.Millisecond)
+	clientMan := NewAuthenticatedClientManager(
+		stateMan.NewClient,
+		ids,
+		secrets,
+		time.Second,
+	)
+	recorder := &mockGameRecorder{}
+
+	handler, done := GameHandler(
+		context.Background(),
+		connMan,
+		clientMan,
+		stateMan,
+		recorder,
+	)
+
+	url, ts := setupTestServer(handler)
+	defer ts.Close()
+	origin := "http://localhost/"
+	for i := 0; i < 2; i++ {
+		config, err := websocket.NewConfig(url, origin)
+		if err != nil {
+			t.Error(err)
+		}
+		config.Header.Add("Authorization", secrets[i])
+		conn, err := websocket.DialConfig(config)
+		if err != nil {
+			t.Error(err)
+		}
+		defer conn.Close()
+	}
+
+	// Neither player reads anything, but the game goes on without them.
+	<-done
+
+	if !state.Finished() {
+		t.Error("Game did not finish!")
+	}
+}
+
+// A mock game where silent players add one to their score.
+type mockDefaultState struct {
+	mockState
+}
+
+func (s *mockDefaultState) Default(p int) string {
+	return "1"
+}
@@ -0,0 +1,351 @@
+package game
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/crestonbunch/botbox/common/game/replay"
+	"golang.org/x/net/websocket"
+	"math/rand"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The kinds of state manager a registered game can be played with.
+const (
+	ManagerSynchronized = "synchronized"
+	ManagerTurnBased    = "turnbased"
+	ManagerRealTime     = "realtime"
+)
+
+// A definition describes how to play a game, so that it can be served by name
+// without writing a server for it.
+type Definition struct {
+	Name string
+	// The number of players in a match.
+	Players int
+	// The kind of state manager, one of the Manager constants.
+	Manager string
+	// Return the default settings of a match as a pointer to a struct, which
+	// settings documents are decoded into. Nil if the game has no settings.
+	Settings func() interface{}
+	// Create the initial state of a match from its settings. All random
+	// numbers must be drawn from the given generator.
+	NewState func(settings interface{}, rng *rand.Rand) GameState
+	// How far behind the game spectators watch, so that games with hidden
+	// information do not give anything away to the bots.
+	SpectatorDelay time.Duration
+}
+
+// The parameters of a single match.
+type Match struct {
+	Ids     []string
+	Secrets []string
+	// The team of every player in the order of the ids, or nil if the match is
+	// not played in teams. Only games with a TeamGameState can be played in
+	// teams.
+	Teams Teams
+	Seed  int64
+	// Settings returned by ParseSettings, or nil for the default settings.
+	Settings interface{}
+	// The directory that recordings of the match are written to.
+	Dir string
+}
+
+// Settings that check themselves after they are decoded, e.g., for a board
+// that is too small to play on.
+type SettingsValidator interface {
+	Validate() error
+}
+
+var registry = map[string]Definition{}
+var registryMutex sync.Mutex
+
+// Register a game so that it can be looked up by name. Games usually register
+// themselves when their package is imported. Panics if the name is taken.
+func Register(d Definition) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, ok := registry[d.Name]; ok {
+		panic("Game " + d.Name + " is already registered.")
+	}
+	registry[d.Name] = d
+}
+
+// Find a registered game by name.
+func Lookup(name string) (Definition, error) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	d, ok := registry[name]
+	if !ok {
+		return Definition{}, errors.New("Game " + name + " is not registered.")
+	}
+
+	return d, nil
+}
+
+// Return the names of every registered game in order.
+func Games() []string {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	names := []string{}
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Decode a settings document over the default settings of the game and
+// validate them. Unknown settings are an error, so that typos are not silently
+// ignored. An empty document gives the default settings.
+func (d Definition) ParseSettings(doc []byte) (interface{}, error) {
+	doc = bytes.TrimSpace(doc)
+	empty := len(doc) == 0 || string(doc) == "null" || string(doc) == "{}"
+	if d.Settings == nil {
+		if !empty {
+			return nil, errors.New("Game " + d.Name + " has no settings.")
+		}
+		return nil, nil
+	}
+
+	settings := d.Settings()
+	if !empty {
+		dec := json.NewDecoder(bytes.NewReader(doc))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(settings)
+		if err != nil {
+			return nil, errors.New("Invalid settings: " + err.Error())
+		}
+	}
+	if t, ok := settings.(TimedSettings); ok {
+		if err := t.Times().check(); err != nil {
+			return nil, errors.New("Invalid settings: " + err.Error())
+		}
+	}
+	if v, ok := settings.(SettingsValidator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, errors.New("Invalid settings: " + err.Error())
+		}
+	}
+
+	return settings, nil
+}
+
+// Return the settings of a match, which are the default settings if the match
+// was not given any.
+func (d Definition) settings(match Match) interface{} {
+	if match.Settings == nil && d.Settings != nil {
+		return d.Settings()
+	}
+	return match.Settings
+}
+
+// Build a state manager of the kind the game is played with, and return it
+// along with the constructor for its clients. Synchronized and turn-based games
+// are played with the given time controls, while real-time games tick once
+// every move time and can not have a time bank. Every game is stopped at the
+// given limits.
+func (d Definition) stateManager(
+	state GameState, control TimeControl, limits Limits,
+) (StateManager, func(id string, conn *websocket.Conn) GameClient, error) {
+	timeout := control.Move
+	if timeout == 0 {
+		timeout = MoveTimeout
+	}
+
+	switch d.Manager {
+	case ManagerSynchronized:
+		m := NewSynchronizedStateManager(state, timeout)
+		m.SetTimeControl(control)
+		m.SetLimits(limits)
+		return m, m.NewClient, nil
+	case ManagerTurnBased:
+		s, ok := state.(TurnBasedGameState)
+		if !ok {
+			return nil, nil, errors.New("Game " + d.Name + " is not turn-based.")
+		}
+		m := NewTurnBasedStateManager(s, timeout, false)
+		m.SetTimeControl(control)
+		m.SetLimits(limits)
+		return m, m.NewClient, nil
+	case ManagerRealTime:
+		// every tick is a move, and there is no clock to bank time on
+		if control.Bank != 0 || control.Increment != 0 {
+			return nil, nil, errors.New("Game " + d.Name + " is played in real time without a time bank.")
+		}
+		tick := control.Move
+		if tick == 0 {
+			tick = TickRate
+		}
+		m := NewRealTimeStateManager(state, tick)
+		m.SetLimits(limits)
+		return m, m.NewClient, nil
+	}
+
+	return nil, nil, errors.New("Unknown state manager " + d.Manager + ".")
+}
+
+// Build the state manager and the recorders of a match, along with the
+// constructor for its clients and its time settings. Settings with
+// TimeSettings embedded choose the time controls and limits, otherwise the
+// defaults are used. The game is told the teams of the match, if it has any.
+// Everything is recorded in the match directory, and broadcast to the
+// spectator if it is not nil.
+func (d Definition) prepare(match Match, spectator *Spectator) (
+	StateManager,
+	func(id string, conn *websocket.Conn) GameClient,
+	*MultiGameRecorder,
+	*TimeSettings,
+	error,
+) {
+	if len(match.Ids) != d.Players {
+		return nil, nil, nil, nil, errors.New("Game " + d.Name + " needs a different number of players.")
+	}
+
+	settings := d.settings(match)
+	times := &TimeSettings{}
+	if t, ok := settings.(TimedSettings); ok {
+		times = t.Times()
+	}
+
+	state := d.NewState(settings, NewRand(match.Seed))
+	err := SetTeams(state, d.Players, match.Teams)
+	if err != nil {
+		return nil, nil, nil, nil, errors.New("Game " + d.Name + ": " + err.Error())
+	}
+	stateMan, constructor, err := d.stateManager(
+		state, times.TimeControl(), times.Limits(),
+	)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	// the recorders that were already opened are closed again if the match
+	// can not be prepared after all
+	recorders := NewMultiGameRecorder()
+	prepared := false
+	defer func() {
+		if !prepared {
+			recorders.Close()
+		}
+	}()
+
+	writer, err := NewSimpleEventRecorder(match.Dir)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	recorders.Recorders = append(recorders.Recorders, writer)
+	recorder, err := NewReplayRecorder(
+		match.Dir, d.Name, match.Seed, settings,
+	)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	recorders.Recorders = append(recorders.Recorders, recorder)
+	recorder.SetKeyframeInterval(replay.DefaultKeyframeInterval)
+	actions, err := NewActionRecorder(match.Dir, match.Seed)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	recorders.Recorders = append(recorders.Recorders, actions)
+	if match.Teams != nil {
+		recorder.SetTeams(match.Teams)
+		err = actions.SetTeams(match.Teams)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+	if spectator != nil {
+		recorders.Recorders = append(recorders.Recorders, spectator)
+	}
+
+	prepared = true
+	return stateMan, constructor, recorders, times, nil
+}
+
+// Build the websocket handler that plays a match of the game, which is set up
+// as described by prepare. Players that disconnect may reconnect within the
+// grace period of the time settings, and every player is greeted with a hello
+// message that it has to reply to in the time the time settings give. The
+// returned channel is closed when the match is over and every recording is
+// complete. Cancelling the context aborts the match.
+func (d Definition) Handler(
+	ctx context.Context, match Match, spectator *Spectator,
+) (websocket.Server, <-chan struct{}, error) {
+	stateMan, constructor, recorders, times, err := d.prepare(match, spectator)
+	if err != nil {
+		return websocket.Server{}, nil, err
+	}
+
+	clientMan := NewAuthenticatedClientManager(
+		constructor, match.Ids, match.Secrets, times.Connect(),
+	)
+	clientMan.SetReconnectGrace(times.Grace())
+	clientMan.SetHandshake(&Handshake{
+		Game:     d.Name,
+		Time:     times.TimeControl(),
+		Settings: d.settings(match),
+		Teams:    match.Teams,
+		Timeout:  times.Hello(),
+	})
+
+	handler, done := GameHandler(
+		ctx,
+		NewSimpleConnectionManager(),
+		clientMan,
+		stateMan,
+		recorders,
+	)
+
+	return handler, done, nil
+}
+
+// Play a match of the game between bot processes that talk over their stdin
+// and stdout instead of websockets, so they need no network. The bots are
+// started in player order, and are trusted to be who they are, so secrets are
+// not used. The returned channel is closed when the match is over and every
+// recording is complete, and the caller then waits for the processes to exit.
+// Cancelling the context aborts the match.
+func (d Definition) Pipe(
+	ctx context.Context, match Match, spectator *Spectator, bots []*exec.Cmd,
+) (<-chan struct{}, error) {
+	if len(bots) != len(match.Ids) {
+		return nil, errors.New("Every player needs a bot.")
+	}
+	stateMan, constructor, recorders, _, err := d.prepare(match, spectator)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := []GameClient{}
+	for i, cmd := range bots {
+		c, err := StartPipeGameClient(constructor(match.Ids[i], nil), cmd)
+		if err != nil {
+			// let the bots that were started see the end of their input
+			for _, c := range clients {
+				c.(*PipeGameClient).Close()
+			}
+			recorders.Close()
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+
+	_, done := GameHandler(
+		ctx,
+		NewSimpleConnectionManager(),
+		NewPipeClientManager(clients...),
+		stateMan,
+		recorders,
+	)
+
+	return done, nil
+}
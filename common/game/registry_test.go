@@ -0,0 +1,509 @@
+package game
+
+import (
+	"context"
+	"github.com/crestonbunch/botbox/common/game/replay"
+	"github.com/crestonbunch/botbox/services/sandbox"
+	"golang.org/x/net/websocket"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newMockDefinition(name string) Definition {
+	return Definition{
+		Name:    name,
+		Players: 2,
+		Manager: ManagerSynchronized,
+		Settings: func() interface{} {
+			return &mockSettings{Goal: 10}
+		},
+		NewState: func(settings interface{}, rng *rand.Rand) GameState {
+			return &mockState{[]int{0, 0}}
+		},
+	}
+}
+
+// Reply to the hello message of the server like an SDK would.
+func greet(t *testing.T, conn *websocket.Conn) HelloMessage {
+	var hello HelloMessage
+	err := websocket.JSON.Receive(conn, &hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = websocket.JSON.Send(conn, &ClientHello{
+		SDK: "test", Version: ProtocolVersion,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hello
+}
+
+func TestRegister(t *testing.T) {
+	Register(newMockDefinition("mock-register-b"))
+	Register(newMockDefinition("mock-register-a"))
+
+	d, err := Lookup("mock-register-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "mock-register-a" || d.Players != 2 {
+		t.Error("Lookup did not return the registered game")
+	}
+	_, err = Lookup("mock-register-c")
+	if err == nil {
+		t.Error("Lookup found a game that was not registered")
+	}
+
+	a, b := -1, -1
+	for i, name := range Games() {
+		if name == "mock-register-a" {
+			a = i
+		} else if name == "mock-register-b" {
+			b = i
+		}
+	}
+	if a < 0 || b < 0 || a > b {
+		t.Error("Games are not listed in order")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Registering a duplicate game did not panic")
+		}
+	}()
+	Register(newMockDefinition("mock-register-a"))
+}
+
+type mockSettings struct {
+	Goal  int    `json:"goal"`
+	Board string `json:"board"`
+}
+
+func TestParseSettings(t *testing.T) {
+	d := newMockDefinition("mock-settings")
+	s, err := d.ParseSettings([]byte(`{"board": "large"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *s.(*mockSettings) != (mockSettings{10, "large"}) {
+		t.Error("Settings were not decoded over the defaults")
+	}
+	s, err = d.ParseSettings(nil)
+	if err != nil || s.(*mockSettings).Goal != 10 {
+		t.Error("Empty settings are not the defaults")
+	}
+	_, err = d.ParseSettings([]byte(`{"gaol": 5}`))
+	if err == nil {
+		t.Error("Unknown settings were accepted")
+	}
+	_, err = d.ParseSettings([]byte(`{"goal": "five"}`))
+	if err == nil {
+		t.Error("Settings of the wrong type were accepted")
+	}
+
+	d.Settings = nil
+	s, err = d.ParseSettings([]byte(" {} "))
+	if err != nil || s != nil {
+		t.Error("Empty settings were not accepted by a game without settings")
+	}
+	_, err = d.ParseSettings([]byte(`{"goal": 5}`))
+	if err == nil {
+		t.Error("Settings were accepted by a game without settings")
+	}
+}
+
+func TestDefinitionRealTime(t *testing.T) {
+	d := newMockDefinition("mock-real-time")
+	d.Manager = ManagerRealTime
+	state := &mockState{[]int{0, 0}}
+
+	m, _, err := d.stateManager(state, TimeControl{}, DefaultLimits())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.(*RealTimeStateManager).tick != TickRate {
+		t.Error("Real-time game does not tick at the default rate")
+	}
+	m, _, err = d.stateManager(
+		state, FixedTimeControl(20*time.Millisecond), DefaultLimits(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.(*RealTimeStateManager).tick != 20*time.Millisecond {
+		t.Error("Real-time game does not tick once every move time")
+	}
+	_, _, err = d.stateManager(
+		state, TimeControl{Bank: time.Second}, DefaultLimits(),
+	)
+	if err == nil {
+		t.Error("Real-time game was given a time bank")
+	}
+}
+
+func TestDefinitionHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := newMockDefinition("mock-handler")
+	ids := []string{"id1", "id2"}
+	secrets := []string{"secret1", "secret2"}
+
+	_, _, err = d.Handler(context.Background(), Match{Ids: ids[:1], Secrets: secrets[:1]}, nil)
+	if err == nil {
+		t.Error("Handler accepted the wrong number of players")
+	}
+	d.Manager = "unknown"
+	_, _, err = d.Handler(context.Background(), Match{Ids: ids, Secrets: secrets, Dir: dir}, nil)
+	if err == nil {
+		t.Error("Handler accepted an unknown state manager")
+	}
+	d.Manager = ManagerSynchronized
+
+	handler, done, err := d.Handler(context.Background(), Match{
+		Ids: ids, Secrets: secrets, Seed: 7, Dir: dir,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, ts := setupTestServer(handler)
+	defer ts.Close()
+	origin := "http://localhost/"
+	for i, action := range []string{"1", "3"} {
+		config, err := websocket.NewConfig(url, origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Header.Add("Authorization", secrets[i])
+		conn, err := websocket.DialConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		greet(t, conn)
+
+		go func(conn *websocket.Conn, action string) {
+			for {
+				var msg ServerMessage
+				err := websocket.JSON.Receive(conn, &msg)
+				if err != nil {
+					return
+				}
+				reply := ClientMessage{Action: StringAction(action)}
+				websocket.JSON.Send(conn, &reply)
+			}
+		}(conn, action)
+	}
+
+	<-done
+
+	r, err := replay.Open(path.Join(dir, sandbox.ReplayFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Header.Game != "mock-handler" || r.Header.Seed != 7 ||
+		string(r.Header.Settings) != `{"goal":10,"board":""}` {
+		t.Error("Replay header does not describe the match")
+	}
+	if r.Footer == nil || r.Footer.Result[1] != ResultWin {
+		t.Error("Replay does not have the result")
+	}
+	if _, err := os.Stat(path.Join(dir, sandbox.StateLogFile)); !os.IsNotExist(err) {
+		t.Error("Match wrote the whole state of every turn to the state log")
+	}
+	if _, err := os.Stat(path.Join(dir, sandbox.ResultLogFile)); err != nil {
+		t.Error("Match did not write the result log")
+	}
+
+	f, err := os.Open(path.Join(dir, sandbox.ActionLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = Verify(func(seed int64) GameState {
+		return d.NewState(nil, NewRand(seed))
+	}, f)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDefinitionPrepareCloses(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("Open files can not be counted on this system")
+	}
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the action log can not be opened, after the other recordings are
+	err = os.Mkdir(path.Join(dir, sandbox.ActionLogFile), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := newMockDefinition("mock-prepare")
+	before, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, _, err = d.prepare(Match{
+		Ids: []string{"id1", "id2"}, Secrets: []string{"s1", "s2"}, Dir: dir,
+	}, nil)
+	if err == nil {
+		t.Fatal("Match was prepared without an action log")
+	}
+	after, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Errorf("%d files were left open", len(after)-len(before))
+	}
+}
+
+func TestDefinitionHandlerReconnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := newMockDefinition("mock-handler-reconnect")
+	secrets := []string{"secret1", "secret2"}
+	handler, done, err := d.Handler(context.Background(), Match{
+		Ids: []string{"id1", "id2"}, Secrets: secrets, Dir: dir,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, ts := setupTestServer(handler)
+	defer ts.Close()
+	dial := func(secret string) *websocket.Conn {
+		config, err := websocket.NewConfig(url, "http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Header.Add("Authorization", secret)
+		conn, err := websocket.DialConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		greet(t, conn)
+		return conn
+	}
+	play := func(conn *websocket.Conn, action string) {
+		for {
+			var msg ServerMessage
+			err := websocket.JSON.Receive(conn, &msg)
+			if err != nil {
+				return
+			}
+			reply := ClientMessage{Action: StringAction(action)}
+			websocket.JSON.Send(conn, &reply)
+		}
+	}
+
+	first := dial(secrets[0])
+	second := dial(secrets[1])
+	defer second.Close()
+	go play(second, "3")
+
+	// the first player drops its connection after the first state, and comes
+	// back with the same secret within the default grace period
+	var msg ServerMessage
+	err = websocket.JSON.Receive(first, &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+	time.Sleep(50 * time.Millisecond)
+	again := dial(secrets[0])
+	defer again.Close()
+	go play(again, "1")
+
+	<-done
+
+	b, err := ioutil.ReadFile(path.Join(dir, sandbox.ReconnectLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "id1\n" {
+		t.Error("Reconnection was not recorded: " + string(b))
+	}
+}
+
+func TestDefinitionHandlerHello(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := newMockDefinition("mock-handler-hello")
+	secrets := []string{"secret1", "secret2"}
+	dial := func(url, secret string) *websocket.Conn {
+		config, err := websocket.NewConfig(url, "http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Header.Add("Authorization", secret)
+		conn, err := websocket.DialConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	// players are greeted even if the settings say nothing about it
+	ctx, cancel := context.WithCancel(context.Background())
+	handler, done, err := d.Handler(ctx, Match{
+		Ids: []string{"id1", "id2"}, Secrets: secrets, Dir: dir,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, ts := setupTestServer(handler)
+	conn := dial(url, secrets[0])
+	var hello HelloMessage
+	err = websocket.JSON.Receive(conn, &hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hello.Type != MessageHello || hello.Game != "mock-handler-hello" ||
+		hello.Player != 0 || hello.Players != 2 || hello.Settings == nil {
+		t.Error("Hello message does not describe the match")
+	}
+	cancel()
+	<-done
+	conn.Close()
+	ts.Close()
+
+	// the settings only choose how long players have to reply
+	d.Settings = func() interface{} {
+		return &TimeSettings{HelloTimeout: 50}
+	}
+	settings, err := d.ParseSettings(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	handler, done, err = d.Handler(ctx, Match{
+		Ids: []string{"id1", "id2"}, Secrets: secrets, Settings: settings,
+		Dir: dir,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, ts = setupTestServer(handler)
+	defer ts.Close()
+	conn = dial(url, secrets[0])
+	defer conn.Close()
+	err = websocket.JSON.Receive(conn, &hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg ServerMessage
+	conn.SetDeadline(time.Now().Add(time.Second))
+	err = websocket.JSON.Receive(conn, &msg)
+	if err != nil || msg.Type != MessageError || msg.Code != ErrorBadHandshake {
+		t.Error("Client that did not reply in time was not rejected: ", msg)
+	}
+	cancel()
+	<-done
+}
+
+func TestDefinitionHandlerTeams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := newMockDefinition("mock-handler-teams")
+	d.Players = 4
+	d.NewState = func(settings interface{}, rng *rand.Rand) GameState {
+		return &mockTeamState{mockState: mockState{make([]int, 4)}}
+	}
+	ids := []string{"id1", "id2", "id3", "id4"}
+	secrets := []string{"secret1", "secret2", "secret3", "secret4"}
+	teams := Teams{"red", "blue", "red", "blue"}
+	handler, done, err := d.Handler(context.Background(), Match{
+		Ids: ids, Secrets: secrets, Teams: teams, Dir: dir,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, ts := setupTestServer(handler)
+	defer ts.Close()
+
+	// the bots connect in the reverse order of their ids, but still play as the
+	// player of their id, on the team of their id
+	actions := []string{"1", "3", "1", "1"}
+	teammates := [][]int{{2}, {3}, {0}, {1}}
+	for i := len(ids) - 1; i >= 0; i-- {
+		config, err := websocket.NewConfig(url, "http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Header.Add("Authorization", secrets[i])
+		conn, err := websocket.DialConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		hello := greet(t, conn)
+		if hello.Player != i || !reflect.DeepEqual(hello.Teammates, teammates[i]) {
+			t.Error("Bot ", ids[i], " was greeted as player ", hello.Player,
+				" with teammates ", hello.Teammates)
+		}
+
+		go func(conn *websocket.Conn, action string) {
+			for {
+				var msg ServerMessage
+				err := websocket.JSON.Receive(conn, &msg)
+				if err != nil {
+					return
+				}
+				reply := ClientMessage{Action: StringAction(action)}
+				websocket.JSON.Send(conn, &reply)
+			}
+		}(conn, actions[i])
+	}
+
+	<-done
+
+	r, err := replay.Open(path.Join(dir, sandbox.ReplayFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Header.Players, ids) ||
+		!reflect.DeepEqual(r.Header.Teams, []string(teams)) {
+		t.Error("Replay players are not in the order of their ids: ",
+			r.Header.Players, r.Header.Teams)
+	}
+	if r.Footer == nil ||
+		!reflect.DeepEqual(r.Footer.Result, []int{-1, 1, -1, 1}) {
+		t.Fatal("The blue team did not win")
+	}
+	for i, result := range r.Footer.Results {
+		if result.Team != teams[i] {
+			t.Error("Player ", ids[i], " was placed on team ", result.Team)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package game
+
+import (
+	"encoding/json"
+	"github.com/crestonbunch/botbox/common/game/replay"
+	"github.com/crestonbunch/botbox/services/sandbox"
+	"os"
+	"path"
+	"time"
+)
+
+// A replay recorder writes the whole game to a single compressed replay file
+// that can be read with the replay package. The header is written when the
+// first turn is recorded, once every client has connected.
+type ReplayRecorder struct {
+	file     *os.File
+	writer   *replay.Writer
+	header   replay.Header
+	interval int
+	result   []int
+	results  []replay.Result
+	reason   string
+}
+
+// Create a new replay recorder that writes to the replay file in the given
+// directory. The game name, seed and settings are recorded in the header.
+func NewReplayRecorder(
+	dir, game string, seed int64, settings interface{},
+) (*ReplayRecorder, error) {
+	var raw json.RawMessage
+	if settings != nil {
+		b, err := json.Marshal(settings)
+		if err != nil {
+			return nil, err
+		}
+		raw = b
+	}
+
+	f := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	file, err := os.OpenFile(path.Join(dir, sandbox.ReplayFile), f, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReplayRecorder{
+		file: file,
+		header: replay.Header{
+			Game:     game,
+			Seed:     seed,
+			Players:  []string{},
+			Settings: raw,
+			Started:  time.Now(),
+		},
+	}, nil
+}
+
+// Write a full state only every n turns, and patches in between. By default
+// every turn holds the full state. Must be called before any turn is recorded.
+func (r *ReplayRecorder) SetKeyframeInterval(n int) {
+	r.interval = n
+}
+
+// Write the header if it has not been written yet.
+func (r *ReplayRecorder) start() error {
+	if r.writer != nil {
+		return nil
+	}
+
+	w, err := replay.NewWriter(r.file, r.header)
+	if err != nil {
+		return err
+	}
+	w.SetKeyframeInterval(r.interval)
+	r.writer = w
+
+	return nil
+}
+
+func (r *ReplayRecorder) LogTurn(t Turn) error {
+	err := r.start()
+	if err != nil {
+		return err
+	}
+
+	times := make([]int64, len(t.Times))
+	for i, d := range t.Times {
+		times[i] = int64(d / time.Millisecond)
+	}
+
+	return r.writer.WriteTurn(replay.Turn{
+		Turn:    t.Number,
+		Actions: t.Actions,
+		Times:   times,
+		State:   t.State,
+	})
+}
+
+func (r *ReplayRecorder) LogResult(s GameState) error {
+	r.result = s.Result()
+	r.results = Rank(s)
+	if a, ok := s.(*AdjudicatedState); ok {
+		r.reason = a.Reason
+	}
+	return nil
+}
+
+// Record the teams of a match in the header. Must be called before any turn is
+// recorded.
+func (r *ReplayRecorder) SetTeams(teams Teams) {
+	r.header.Teams = teams
+}
+
+// Players are recorded in player order, which is the order of their ids in the
+// match, no matter when they connect.
+func (r *ReplayRecorder) LogConnection(c GameClient) error {
+	r.header.Players = append(r.header.Players, c.Id())
+	return nil
+}
+
+func (r *ReplayRecorder) LogDisconnection(c GameClient) error {
+	return nil
+}
+
+func (r *ReplayRecorder) LogReconnection(c GameClient) error {
+	return nil
+}
+
+func (r *ReplayRecorder) LogViolation(c GameClient, v ViolationError) error {
+	return nil
+}
+
+// Write the footer and close the replay file.
+func (r *ReplayRecorder) Close() error {
+	defer r.file.Close()
+
+	err := r.start()
+	if err != nil {
+		return err
+	}
+	err = r.writer.WriteFooter(replay.Footer{
+		Result:      r.result,
+		Results:     r.results,
+		Adjudicated: r.reason,
+		Finished:    time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.writer.Close()
+}
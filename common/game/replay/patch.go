@@ -0,0 +1,273 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A JSON Patch (RFC 6902) operation. Only the add, remove and replace
+// operations are used to encode the difference between two states.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type Patch []Operation
+
+// Compute a patch that turns document a into document b.
+func Diff(a, b json.RawMessage) (Patch, error) {
+	x, err := decode(a)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decode(b)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := Patch{}
+	err = diff(&patch, "", x, y)
+	if err != nil {
+		return nil, err
+	}
+
+	return patch, nil
+}
+
+// Apply a patch to a document and return the new document.
+func Apply(doc json.RawMessage, patch Patch) (json.RawMessage, error) {
+	x, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	x, err = apply(x, patch)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(x)
+}
+
+// Decode a document keeping numbers exactly as they were written.
+func decode(doc json.RawMessage) (interface{}, error) {
+	var x interface{}
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	err := dec.Decode(&x)
+	if err != nil {
+		return nil, err
+	}
+
+	return x, nil
+}
+
+func diff(patch *Patch, path string, a, b interface{}) error {
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		// visit keys in order so that the same states give the same patch
+		keys := []string{}
+		for k := range x {
+			keys = append(keys, k)
+		}
+		for k := range y {
+			if _, ok := x[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			p := path + "/" + escape(k)
+			u, inA := x[k]
+			v, inB := y[k]
+			if !inB {
+				*patch = append(*patch, Operation{Op: "remove", Path: p})
+			} else if !inA {
+				err := patch.add("add", p, v)
+				if err != nil {
+					return err
+				}
+			} else {
+				err := diff(patch, p, u, v)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		n := len(x)
+		if len(y) < n {
+			n = len(y)
+		}
+		for i := 0; i < n; i++ {
+			err := diff(patch, path+"/"+strconv.Itoa(i), x[i], y[i])
+			if err != nil {
+				return err
+			}
+		}
+		// remove from the end first so that indices stay valid
+		for i := len(x) - 1; i >= n; i-- {
+			p := path + "/" + strconv.Itoa(i)
+			*patch = append(*patch, Operation{Op: "remove", Path: p})
+		}
+		for i := n; i < len(y); i++ {
+			err := patch.add("add", path+"/"+strconv.Itoa(i), y[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return patch.add("replace", path, b)
+}
+
+func (patch *Patch) add(op, path string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	*patch = append(*patch, Operation{Op: op, Path: path, Value: raw})
+
+	return nil
+}
+
+func apply(doc interface{}, patch Patch) (interface{}, error) {
+	for _, op := range patch {
+		var value interface{}
+		if op.Op != "remove" {
+			v, err := decode(op.Value)
+			if err != nil {
+				return nil, err
+			}
+			value = v
+		}
+
+		tokens, err := split(op.Path)
+		if err != nil {
+			return nil, err
+		}
+		doc, err = update(doc, tokens, op.Op, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+// Apply a single operation at the path given by the tokens and return the
+// updated document.
+func update(
+	doc interface{}, tokens []string, op string, value interface{},
+) (interface{}, error) {
+	if len(tokens) == 0 {
+		if op == "remove" {
+			return nil, nil
+		}
+		return value, nil
+	}
+
+	token := tokens[0]
+	last := len(tokens) == 1
+
+	switch x := doc.(type) {
+	case map[string]interface{}:
+		child, ok := x[token]
+		if last {
+			switch {
+			case op == "remove" && !ok, op == "replace" && !ok:
+				return nil, errors.New("Patch path does not exist.")
+			case op == "remove":
+				delete(x, token)
+			default:
+				x[token] = value
+			}
+			return x, nil
+		}
+		if !ok {
+			return nil, errors.New("Patch path does not exist.")
+		}
+		child, err := update(child, tokens[1:], op, value)
+		if err != nil {
+			return nil, err
+		}
+		x[token] = child
+		return x, nil
+	case []interface{}:
+		i := len(x)
+		if token != "-" {
+			n, err := strconv.Atoi(token)
+			if err != nil || n < 0 || n > len(x) {
+				return nil, errors.New("Patch index is out of range.")
+			}
+			i = n
+		}
+		if last && op == "add" {
+			x = append(x, nil)
+			copy(x[i+1:], x[i:])
+			x[i] = value
+			return x, nil
+		}
+		if i == len(x) {
+			return nil, errors.New("Patch index is out of range.")
+		}
+		if last && op == "remove" {
+			return append(x[:i], x[i+1:]...), nil
+		}
+		if last {
+			x[i] = value
+			return x, nil
+		}
+		child, err := update(x[i], tokens[1:], op, value)
+		if err != nil {
+			return nil, err
+		}
+		x[i] = child
+		return x, nil
+	}
+
+	return nil, errors.New("Patch path does not exist.")
+}
+
+// Split a JSON Pointer (RFC 6901) into unescaped tokens.
+func split(path string) ([]string, error) {
+	if path == "" {
+		return []string{}, nil
+	}
+	if path[0] != '/' {
+		return nil, errors.New("Patch path must start with a slash.")
+	}
+
+	tokens := strings.Split(path[1:], "/")
+	for i, t := range tokens {
+		t = strings.Replace(t, "~1", "/", -1)
+		tokens[i] = strings.Replace(t, "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
+
+func escape(token string) string {
+	token = strings.Replace(token, "~", "~0", -1)
+	return strings.Replace(token, "/", "~1", -1)
+}
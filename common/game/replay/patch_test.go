@@ -0,0 +1,73 @@
+package replay
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func checkRoundTrip(t *testing.T, a, b string) Patch {
+	patch, err := Diff(json.RawMessage(a), json.RawMessage(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Apply(json.RawMessage(a), patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var x, y interface{}
+	json.Unmarshal(result, &x)
+	json.Unmarshal([]byte(b), &y)
+	if !reflect.DeepEqual(x, y) {
+		t.Errorf("Patch from %s gives %s instead of %s", a, result, b)
+	}
+
+	return patch
+}
+
+func TestDiff(t *testing.T) {
+	checkRoundTrip(t, `{"a":1}`, `{"a":1}`)
+	checkRoundTrip(t, `{"a":1,"b":2}`, `{"a":3,"c":4}`)
+	checkRoundTrip(t, `{"a":{"b":[1,2,3]}}`, `{"a":{"b":[1,5]}}`)
+	checkRoundTrip(t, `[1,2]`, `[1,2,3,4]`)
+	checkRoundTrip(t, `{"a":[1]}`, `{"a":"x"}`)
+	checkRoundTrip(t, `{"a":1}`, `{"a":null}`)
+	checkRoundTrip(t, `1`, `[1]`)
+	checkRoundTrip(t, `{"a/b":{"c~d":1}}`, `{"a/b":{"c~d":2}}`)
+}
+
+func TestDiffIsSmall(t *testing.T) {
+	patch := checkRoundTrip(t,
+		`{"cells":{"0":{"0":0,"1":0}},"players":[{"x":0,"y":2}]}`,
+		`{"cells":{"0":{"0":0,"1":0,"2":0}},"players":[{"x":0,"y":3}]}`,
+	)
+
+	b, _ := json.Marshal(patch)
+	expected := `[{"op":"add","path":"/cells/0/2","value":0},` +
+		`{"op":"replace","path":"/players/0/y","value":3}]`
+	if string(b) != expected {
+		t.Error("Patch is not minimal: " + string(b))
+	}
+}
+
+func TestApply(t *testing.T) {
+	patch := Patch{
+		{Op: "add", Path: "/a/1", Value: json.RawMessage(`5`)},
+		{Op: "add", Path: "/a/-", Value: json.RawMessage(`6`)},
+		{Op: "remove", Path: "/b"},
+		{Op: "replace", Path: "/c~1d", Value: json.RawMessage(`true`)},
+	}
+	result, err := Apply(json.RawMessage(`{"a":[1,2],"b":0,"c/d":false}`), patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != `{"a":[1,5,2,6],"c/d":true}` {
+		t.Error("Patch was not applied: " + string(result))
+	}
+
+	_, err = Apply(json.RawMessage(`{}`), Patch{{Op: "remove", Path: "/x"}})
+	if err == nil {
+		t.Error("Removing a missing path did not fail")
+	}
+}
@@ -0,0 +1,139 @@
+package replay
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+)
+
+// A reader loads a whole replay so that it can be stepped through turn by
+// turn or seek straight to any turn.
+type Reader struct {
+	Header Header
+	// The footer is nil if the replay was cut off before the game ended.
+	Footer *Footer
+	turns  []Turn
+	next   int
+	// the full state of the turn at the given index, kept so that stepping
+	// through a replay only applies one patch per turn
+	doc json.RawMessage
+	at  int
+}
+
+// Read a compressed replay.
+func NewReader(r io.Reader) (*Reader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(gz))
+	var header record
+	err = dec.Decode(&header)
+	if err != nil {
+		return nil, err
+	}
+	if header.Header == nil {
+		return nil, errors.New("Replay does not start with a header.")
+	}
+	if header.Header.Version > FormatVersion {
+		return nil, errors.New("Replay format version is not supported.")
+	}
+
+	reader := &Reader{Header: *header.Header, at: -1}
+	for {
+		var rec record
+		err := dec.Decode(&rec)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// a replay that was cut off is still worth reading
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if rec.Turn != nil {
+			reader.turns = append(reader.turns, *rec.Turn)
+		} else if rec.Footer != nil {
+			reader.Footer = rec.Footer
+		}
+	}
+
+	return reader, nil
+}
+
+// Open a replay file.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return NewReader(f)
+}
+
+// Return the number of turns in the replay.
+func (r *Reader) Len() int {
+	return len(r.turns)
+}
+
+// Return the next turn in the replay, or io.EOF after the last turn.
+func (r *Reader) Next() (*Turn, error) {
+	if r.next >= len(r.turns) {
+		return nil, io.EOF
+	}
+	t := r.turns[r.next]
+	state, err := r.state(r.next)
+	if err != nil {
+		return nil, err
+	}
+	t.State = state
+	t.Patch = nil
+	r.next++
+
+	return &t, nil
+}
+
+// Rebuild the full state of the turn at index i from the last keyframe.
+func (r *Reader) state(i int) (json.RawMessage, error) {
+	k := i
+	for k >= 0 && r.turns[k].State == nil {
+		k--
+	}
+	if k < 0 {
+		return nil, errors.New("Replay does not start with a keyframe.")
+	}
+
+	if r.at < k || r.at > i {
+		r.doc = r.turns[k].State
+		r.at = k
+	}
+	for r.at < i {
+		doc, err := Apply(r.doc, r.turns[r.at+1].Patch)
+		if err != nil {
+			return nil, err
+		}
+		r.doc = doc
+		r.at++
+	}
+
+	return r.doc, nil
+}
+
+// Move to the given turn number, so that it is the next turn returned.
+func (r *Reader) Seek(turn int) error {
+	i := sort.Search(len(r.turns), func(i int) bool {
+		return r.turns[i].Turn >= turn
+	})
+	if i == len(r.turns) || r.turns[i].Turn != turn {
+		return errors.New("Turn is not in the replay.")
+	}
+	r.next = i
+
+	return nil
+}
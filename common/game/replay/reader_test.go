@@ -0,0 +1,134 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	r, err := NewReader(writeTestReplay(t, 3, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Header.Game != "mock" || len(r.Header.Players) != 2 {
+		t.Error("Header was not read")
+	}
+	if r.Footer == nil || r.Footer.Result[0] != 1 ||
+		r.Footer.Results[1].Place != 2 {
+		t.Error("Footer was not read")
+	}
+	if r.Len() != 3 {
+		t.Fatal("Replay does not have 3 turns")
+	}
+
+	for i := 0; i < 3; i++ {
+		turn, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if turn.Turn != i || string(turn.State) != strconv.Itoa(i) {
+			t.Errorf("Turn %d was not read in order", i)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Error("Reader did not stop after the last turn")
+	}
+}
+
+func TestReaderSeek(t *testing.T) {
+	r, err := NewReader(writeTestReplay(t, 5, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Seek(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	turn, err := r.Next()
+	if err != nil || turn.Turn != 3 {
+		t.Error("Reader did not seek to turn 3")
+	}
+
+	err = r.Seek(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	turn, err = r.Next()
+	if err != nil || turn.Turn != 0 {
+		t.Error("Reader did not seek back to the start")
+	}
+
+	if r.Seek(5) == nil {
+		t.Error("Reader seeked past the last turn")
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	buf := writeTestReplay(t, 2, false)
+	// cut the replay off in the middle of the compressed stream
+	truncated := bytes.NewReader(buf.Bytes()[:buf.Len()-8])
+
+	r, err := NewReader(truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Footer != nil {
+		t.Error("Truncated replay has a footer")
+	}
+}
+
+func TestReaderKeyframes(t *testing.T) {
+	states := []string{}
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, Header{Game: "mock"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetKeyframeInterval(3)
+	for i := 0; i < 7; i++ {
+		state := `{"trail":[` + strings.Repeat("0,", i) + `0],"turn":` +
+			strconv.Itoa(i) + `}`
+		states = append(states, state)
+		err = w.WriteTurn(Turn{Turn: i, State: json.RawMessage(state)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.turns[1].State != nil || r.turns[3].State == nil {
+		t.Error("Keyframes were not written every 3 turns")
+	}
+
+	for i := 0; i < 7; i++ {
+		turn, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(turn.State) != states[i] {
+			t.Errorf("Turn %d state is %s", i, turn.State)
+		}
+	}
+
+	// seeking backwards and forwards between keyframes rebuilds the state
+	for _, i := range []int{5, 1, 2, 6} {
+		r.Seek(i)
+		turn, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(turn.State) != states[i] {
+			t.Errorf("Turn %d state is %s after seeking", i, turn.State)
+		}
+	}
+}
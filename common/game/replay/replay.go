@@ -0,0 +1,117 @@
+// This package reads and writes replays of games. A replay is a gzip
+// compressed file with one JSON record per line: a header describing the
+// match, then one record for every turn, then a footer with the result. Turns
+// may hold the full state of the game, or a JSON Patch from the state of the
+// previous turn.
+package replay
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// The version of the replay format written by this package.
+const FormatVersion = 1
+
+// A reasonable number of turns between full states for long games.
+const DefaultKeyframeInterval = 50
+
+// The header is the first record of a replay and describes the match. Matches
+// played in teams hold the team id of every player.
+type Header struct {
+	Version  int             `json:"version"`
+	Game     string          `json:"game"`
+	Seed     int64           `json:"seed"`
+	Players  []string        `json:"players"`
+	Teams    []string        `json:"teams,omitempty"`
+	Settings json.RawMessage `json:"settings,omitempty"`
+	Started  time.Time       `json:"started"`
+}
+
+// A turn holds the action each player committed, how long each player took to
+// choose it in milliseconds, and the resulting state. Turns between keyframes
+// only hold a patch from the state of the previous turn, but the reader always
+// fills in the full state.
+type Turn struct {
+	Turn    int               `json:"turn"`
+	Actions []json.RawMessage `json:"actions"`
+	Times   []int64           `json:"times"`
+	State   json.RawMessage   `json:"state,omitempty"`
+	Patch   Patch             `json:"patch,omitempty"`
+}
+
+// The footer is the last record of a replay. The result holds the win (+1),
+// tie (0) or loss (-1) of every player, and the results hold their places.
+// Both are missing if the game did not finish. Matches that were stopped at a
+// limit before the game finished note why their result was adjudicated.
+type Footer struct {
+	Result      []int     `json:"result,omitempty"`
+	Results     []Result  `json:"results,omitempty"`
+	Adjudicated string    `json:"adjudicated,omitempty"`
+	Finished    time.Time `json:"finished"`
+}
+
+// Every line of a replay is a record holding exactly one of its fields.
+type record struct {
+	Header *Header `json:"header,omitempty"`
+	Turn   *Turn   `json:"turn,omitempty"`
+	Footer *Footer `json:"footer,omitempty"`
+}
+
+// A writer compresses a replay as it is recorded.
+type Writer struct {
+	gz       *gzip.Writer
+	enc      *json.Encoder
+	interval int
+	count    int
+	last     json.RawMessage
+}
+
+// Create a new replay writer and write the header. Closing the writer does
+// not close the underlying writer.
+func NewWriter(w io.Writer, h Header) (*Writer, error) {
+	gz := gzip.NewWriter(w)
+	h.Version = FormatVersion
+	writer := &Writer{gz: gz, enc: json.NewEncoder(gz)}
+
+	err := writer.enc.Encode(record{Header: &h})
+	if err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// Write the full state only every n turns, and a patch from the previous state
+// in between, so that long games do not write the same state over and over.
+// By default every turn holds the full state.
+func (w *Writer) SetKeyframeInterval(n int) {
+	w.interval = n
+}
+
+func (w *Writer) WriteTurn(t Turn) error {
+	state := t.State
+	if w.interval > 0 && w.count%w.interval != 0 && w.last != nil {
+		patch, err := Diff(w.last, state)
+		if err != nil {
+			return err
+		}
+		t.State = nil
+		t.Patch = patch
+	}
+	w.last = state
+	w.count++
+
+	return w.enc.Encode(record{Turn: &t})
+}
+
+func (w *Writer) WriteFooter(f Footer) error {
+	return w.enc.Encode(record{Footer: &f})
+}
+
+// Flush the compressed replay to the underlying writer.
+func (w *Writer) Close() error {
+	return w.gz.Close()
+}
@@ -0,0 +1,82 @@
+package replay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Write a replay with the given number of turns, where the state of each turn
+// is its turn number.
+func writeTestReplay(t *testing.T, turns int, footer bool) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, Header{
+		Game:     "mock",
+		Players:  []string{"a", "b"},
+		Settings: json.RawMessage(`{"width":32}`),
+		Started:  time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < turns; i++ {
+		state, _ := json.Marshal(i)
+		err = w.WriteTurn(Turn{
+			Turn:    i,
+			Actions: []json.RawMessage{json.RawMessage(`"north"`), nil},
+			Times:   []int64{5, 0},
+			State:   state,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if footer {
+		err = w.WriteFooter(Footer{
+			Result:   []int{1, -1},
+			Results:  Places([]int{1, -1}),
+			Finished: time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf
+}
+
+func TestWriter(t *testing.T) {
+	buf := writeTestReplay(t, 2, true)
+
+	gz, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	if len(lines) != 4 {
+		t.Fatal("Replay does not have a header, two turns and a footer")
+	}
+	if !strings.HasPrefix(lines[0], `{"header":{"version":1,"game":"mock"`) {
+		t.Error("Replay does not start with the header")
+	}
+	if lines[1] != `{"turn":{"turn":0,"actions":["north",null],"times":[5,0],"state":0}}` {
+		t.Error("Turn was not written: " + lines[1])
+	}
+	if !strings.HasPrefix(lines[3], `{"footer":{"result":[1,-1]`) {
+		t.Error("Replay does not end with the footer")
+	}
+}
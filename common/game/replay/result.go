@@ -0,0 +1,54 @@
+package replay
+
+import (
+	"encoding/json"
+)
+
+// The result of a single player. Players are placed in the order they
+// finished, starting at 1, and players that tie share a place. Games may also
+// give each player a score, and a human readable reason for its result, e.g.,
+// "crashed at turn 57". Players of matches played in teams carry the id of
+// their team.
+type Result struct {
+	Place  int      `json:"place"`
+	Score  *float64 `json:"score,omitempty"`
+	Reason string   `json:"reason,omitempty"`
+	Team   string   `json:"team,omitempty"`
+}
+
+// Place players by their win (+1), tie (0) or loss (-1) results. Every player
+// is placed one behind the number of players that did better, so winners are
+// first, and players that tie share a place.
+func Places(outcomes []int) []Result {
+	results := make([]Result, len(outcomes))
+	for i, o := range outcomes {
+		results[i].Place = 1
+		for _, p := range outcomes {
+			if p > o {
+				results[i].Place++
+			}
+		}
+	}
+	return results
+}
+
+// Parse the results of a game, which are either a list of results, or a list
+// of win, tie or loss numbers written before games could place players.
+func ParseResults(b []byte) ([]Result, error) {
+	results := []Result{}
+	err := json.Unmarshal(b, &results)
+	if err == nil {
+		return results, nil
+	}
+
+	outcomes := []float64{}
+	if json.Unmarshal(b, &outcomes) != nil {
+		return nil, err
+	}
+	legacy := make([]int, len(outcomes))
+	for i, o := range outcomes {
+		legacy[i] = int(o)
+	}
+
+	return Places(legacy), nil
+}
@@ -0,0 +1,46 @@
+package replay
+
+import (
+	"testing"
+)
+
+func TestPlaces(t *testing.T) {
+	results := Places([]int{-1, 1, -1})
+	if results[0].Place != 2 || results[1].Place != 1 || results[2].Place != 2 {
+		t.Error("Winner was not placed ahead of the losers")
+	}
+
+	results = Places([]int{0, 0, -1})
+	if results[0].Place != 1 || results[1].Place != 1 || results[2].Place != 3 {
+		t.Error("Players that tie do not share a place")
+	}
+}
+
+func TestParseResults(t *testing.T) {
+	results, err := ParseResults([]byte(
+		`[{"place":2,"score":10,"reason":"crashed","team":"red"},{"place":1}]`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Place != 2 || *results[0].Score != 10 ||
+		results[0].Reason != "crashed" || results[1].Score != nil {
+		t.Error("Results were not parsed")
+	}
+	if results[0].Team != "red" || results[1].Team != "" {
+		t.Error("Team ids were not parsed")
+	}
+
+	results, err = ParseResults([]byte(`[1, -1]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Place != 1 || results[1].Place != 2 {
+		t.Error("Win and loss results were not placed")
+	}
+
+	_, err = ParseResults([]byte(`{"place":1}`))
+	if err == nil {
+		t.Error("Results that are not a list were parsed")
+	}
+}
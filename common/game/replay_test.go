@@ -0,0 +1,112 @@
+package game
+
+import (
+	"encoding/json"
+	"github.com/crestonbunch/botbox/common/game/replay"
+	"github.com/crestonbunch/botbox/services/sandbox"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestReplayRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewReplayRecorder(dir, "mock", 42, map[string]int{"width": 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.LogConnection(&SynchronizedGameClient{id: "123abc"})
+	r.LogConnection(&SynchronizedGameClient{id: "456def"})
+	state := &mockState{[]int{1, 3}}
+	r.LogTurn(takeTurn(
+		0,
+		[]json.RawMessage{StringAction("1"), StringAction("3")},
+		[]time.Duration{5 * time.Millisecond, 0},
+		state,
+	))
+	state = &mockState{[]int{10, 3}}
+	r.LogTurn(takeTurn(
+		1,
+		[]json.RawMessage{StringAction("9"), nil},
+		[]time.Duration{0, 0},
+		state,
+	))
+	r.LogResult(state)
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := replay.Open(path.Join(dir, sandbox.ReplayFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := reader.Header
+	if h.Game != "mock" || h.Seed != 42 || string(h.Settings) != `{"width":32}` {
+		t.Error("Replay header does not describe the game")
+	}
+	if len(h.Players) != 2 || h.Players[0] != "123abc" || h.Players[1] != "456def" {
+		t.Error("Replay header does not list the players")
+	}
+	if reader.Len() != 2 {
+		t.Fatal("Replay does not have 2 turns")
+	}
+	turn, _ := reader.Next()
+	if string(turn.Actions[0]) != `"1"` || turn.Times[0] != 5 {
+		t.Error("Replay does not have the actions and times")
+	}
+	if string(turn.State) != `{"players":[1,3]}` {
+		t.Error("Replay does not have the state")
+	}
+	if reader.Footer == nil || reader.Footer.Result[0] != ResultWin {
+		t.Error("Replay does not have the result")
+	}
+	if reader.Footer.Results[0].Place != 1 || reader.Footer.Results[1].Place != 2 {
+		t.Error("Replay does not have the places")
+	}
+}
+
+func TestReplayRecorderKeyframes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewReplayRecorder(dir, "mock", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetKeyframeInterval(2)
+
+	state := &mockState{[]int{0, 0}}
+	for i := 0; i < 5; i++ {
+		state.Players[0] = i
+		r.LogTurn(takeTurn(i, nil, nil, state))
+	}
+	r.Close()
+
+	reader, err := replay.Open(path.Join(dir, sandbox.ReplayFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		turn, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `{"players":[` + strconv.Itoa(i) + `,0]}`
+		if string(turn.State) != expected {
+			t.Errorf("Turn %d state is %s", i, turn.State)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package game
+
+import (
+	"errors"
+	"github.com/crestonbunch/botbox/services/sandbox"
+	"math/rand"
+	"os"
+	"strconv"
+)
+
+// Searches first for the command line argument "seed", and then checks the
+// environment variable. If neither is set, then a random seed is generated
+// so that bots cannot predict the game.
+func FindSeed() (int64, error) {
+	s := seed
+	if s == "" {
+		s = os.Getenv(sandbox.ServerSeedEnvVar)
+	}
+	if s == "" {
+		return sandbox.GenerateSeed()
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errors.New("Seed must be an integer.")
+	}
+
+	return n, nil
+}
+
+// Create the random number generator for a match. Game states with random
+// elements must draw every random number from it, so that replaying the same
+// actions with the same seed gives the same states.
+func NewRand(seed int64) *rand.Rand {
+	return rand.New(rand.NewSource(seed))
+}
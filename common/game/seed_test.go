@@ -0,0 +1,76 @@
+package game
+
+import (
+	"github.com/crestonbunch/botbox/services/sandbox"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestFindSeed(t *testing.T) {
+	err := os.Setenv(sandbox.ServerSeedEnvVar, "1234")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Unsetenv(sandbox.ServerSeedEnvVar)
+
+	n, err := FindSeed()
+	if err != nil || n != 1234 {
+		t.Error("Seed was not read from the environment")
+	}
+
+	seed = "-99"
+	defer func() { seed = "" }()
+	n, err = FindSeed()
+	if err != nil || n != -99 {
+		t.Error("Seed flag does not take priority over the environment")
+	}
+
+	seed = "abc"
+	_, err = FindSeed()
+	if err == nil {
+		t.Error("Seed that is not a number was accepted")
+	}
+}
+
+func TestFindSeedRandom(t *testing.T) {
+	a, err := FindSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := FindSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("The same random seed was generated twice")
+	}
+}
+
+func TestSeededGameIsDeterministic(t *testing.T) {
+	actions := []string{"1", "2", "3", "1", "2"}
+	play := func(seed int64) []int {
+		state := &mockRandomState{mockState{[]int{0, 0}}, NewRand(seed)}
+		for _, a := range actions {
+			Commit(state, 0, StringAction(a))
+			Commit(state, 1, StringAction(a))
+		}
+		return state.Players
+	}
+
+	a, b := play(7), play(7)
+	if a[0] != b[0] || a[1] != b[1] {
+		t.Error("The same seed and actions gave different states")
+	}
+}
+
+// A mock game where every action scores a random bonus.
+type mockRandomState struct {
+	mockState
+	rng *rand.Rand
+}
+
+func (s *mockRandomState) Do(p int, a string) {
+	s.mockState.Do(p, a)
+	s.Players[p] += s.rng.Intn(10)
+}
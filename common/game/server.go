@@ -1,6 +1,7 @@
 package game
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"github.com/crestonbunch/botbox/services/sandbox"
@@ -13,10 +14,22 @@ import (
 
 var ids string
 var secrets string
+var seed string
+var settings string
+var teams string
 
+// Setup the command line flags of a game server. Does nothing if the flags were
+// already parsed, so that servers can add flags of their own and parse them
+// first.
 func SetupFlags() {
+	if flag.Parsed() {
+		return
+	}
 	flag.StringVar(&ids, "ids", "", "A space-delimited list of client ids.")
 	flag.StringVar(&secrets, "secrets", "", "A space-delimited list of client secrets.")
+	flag.StringVar(&seed, "seed", "", "The seed for the match's random numbers.")
+	flag.StringVar(&settings, "settings", "", "A JSON document of game settings.")
+	flag.StringVar(&teams, "teams", "", "A space-delimited list of the team of every client.")
 	flag.Parse()
 }
 
@@ -51,19 +64,49 @@ func FindIdsAndSecrets() ([]string, []string, error) {
 
 }
 
+// Searches first for the command line argument "settings", and then checks the
+// environment variable. Returns an empty document if neither is set, which
+// gives the default settings.
+func FindSettings() []byte {
+	if settings != "" {
+		return []byte(settings)
+	}
+	return []byte(os.Getenv(sandbox.ServerSettingsEnvVar))
+}
+
+// Searches first for the command line argument "teams", and then checks the
+// environment variable. Returns nil if neither is set, and the match is not
+// played in teams.
+func FindTeams() Teams {
+	list := teams
+	if list == "" {
+		list = os.Getenv(sandbox.ServerTeamsEnvVar)
+	}
+	if list == "" {
+		return nil
+	}
+	return Teams(strings.Split(list, sandbox.EnvListSep))
+}
+
 // Given a constructor that creates a websocket handler, wrap it with
 // FindIdsAndSecrets() to authenticate from the command line or environment
-// variables.
+// variables. The constructor is also given the seed of the match from
+// FindSeed().
 func AuthenticateHandler(
-	constructor func(ids, secrets []string) (websocket.Handler, error),
-) (websocket.Handler, error) {
+	constructor func(ids, secrets []string, seed int64) (websocket.Server, error),
+) (websocket.Server, error) {
 	idList, secretList, err := FindIdsAndSecrets()
 	if err != nil {
-		return nil, err
+		return websocket.Server{}, err
+	}
+	seed, err := FindSeed()
+	if err != nil {
+		return websocket.Server{}, err
 	}
 	log.Println(idList)
 	log.Println(secretList)
-	return constructor(idList, secretList)
+	log.Println("Seed:", seed)
+	return constructor(idList, secretList, seed)
 }
 
 // Setup a server to listen to clients.
@@ -76,9 +119,14 @@ func AuthenticateHandler(
 // The secrets are necessary to prevent malicious scripts from trying to connect
 // as two separate agents.
 // Pass in a constructor function that will build the GameHandler from a list
-// of client ids and secrets to expect.
+// of client ids and secrets to expect, and the seed of the match. A seed can be
+// given with --seed or BOTBOX_SEED, otherwise a random one is used. If a
+// spectator is given, then anyone can watch the game by connecting to the
+// SpectatePath. The spectator should also be one of the recorders given to the
+// GameHandler.
 func RunAuthenticatedServer(
-	constructor func(ids, secrets []string) (websocket.Handler, error),
+	constructor func(ids, secrets []string, seed int64) (websocket.Server, error),
+	spectator *Spectator,
 ) {
 	SetupFlags()
 
@@ -87,6 +135,9 @@ func RunAuthenticatedServer(
 		log.Fatal(err)
 	}
 	http.Handle("/", handler)
+	if spectator != nil {
+		http.Handle(SpectatePath, spectator.Handler())
+	}
 
 	err = http.ListenAndServe(":12345", nil)
 	if err != nil {
@@ -94,3 +145,45 @@ func RunAuthenticatedServer(
 	}
 
 }
+
+// Serve a registered game with the ids, secrets, seed, settings and teams given
+// on the command line or in environment variables, e.g.,
+// go run main.go --ids "1 2" --secrets "s1 s2" --settings '{"width": 64}'
+// In a Docker sandbox the settings are given in BOTBOX_SETTINGS, and the teams
+// in BOTBOX_TEAMS. Recordings are written to the working directory, and
+// spectators can watch at the SpectatePath. Returns when the match is over.
+func RunGameServer(d Definition) {
+	exitChan := make(chan bool)
+	spectator := NewSpectator(d.SpectatorDelay)
+
+	go RunAuthenticatedServer(
+		func(ids, secrets []string, seed int64) (websocket.Server, error) {
+			s, err := d.ParseSettings(FindSettings())
+			if err != nil {
+				return websocket.Server{}, err
+			}
+			log.Println("Game:", d.Name)
+
+			handler, done, err := d.Handler(context.Background(), Match{
+				Ids:      ids,
+				Secrets:  secrets,
+				Teams:    FindTeams(),
+				Seed:     seed,
+				Settings: s,
+				Dir:      "./",
+			}, spectator)
+			if err != nil {
+				return websocket.Server{}, err
+			}
+			go func() {
+				<-done
+				exitChan <- true
+			}()
+
+			return handler, nil
+		},
+		spectator,
+	)
+
+	<-exitChan
+}
@@ -1,6 +1,7 @@
 package game
 
 import (
+	"context"
 	"github.com/crestonbunch/botbox/services/sandbox"
 	"golang.org/x/net/websocket"
 	"os"
@@ -10,7 +11,7 @@ import (
 )
 
 func TestAuthenticateHandler(t *testing.T) {
-	exitChan := make(chan bool)
+	var done <-chan struct{}
 	ids := []string{"id1", "id2"}
 	secrets := []string{"secret1", "secret2"}
 	err := os.Setenv(
@@ -28,7 +29,9 @@ func TestAuthenticateHandler(t *testing.T) {
 	}
 	defer os.Unsetenv(sandbox.ServerSecretEnvVar)
 
-	constructor := func(ids, secrets []string) (websocket.Handler, error) {
+	constructor := func(
+		ids, secrets []string, seed int64,
+	) (websocket.Server, error) {
 		connMan := NewSimpleConnectionManager()
 		state := &mockState{[]int{0, 0}}
 		stateMan := NewSynchronizedStateManager(state, time.Second)
@@ -40,13 +43,15 @@ func TestAuthenticateHandler(t *testing.T) {
 		)
 		recorder := &mockGameRecorder{}
 
-		return GameHandler(
-			exitChan,
+		var handler websocket.Server
+		handler, done = GameHandler(
+			context.Background(),
 			connMan,
 			clientMan,
 			stateMan,
 			recorder,
-		), nil
+		)
+		return handler, nil
 	}
 	handler, err := AuthenticateHandler(constructor)
 	if err != nil {
@@ -78,7 +83,7 @@ func TestAuthenticateHandler(t *testing.T) {
 			if err != nil {
 				t.Error(err)
 			}
-			broadcast := ClientMessage{Action: "1"}
+			broadcast := ClientMessage{Action: StringAction("1")}
 			err = websocket.JSON.Send(conns[0], &broadcast)
 			if err != nil {
 				t.Error(err)
@@ -91,7 +96,7 @@ func TestAuthenticateHandler(t *testing.T) {
 			if err != nil {
 				t.Error(err)
 			}
-			broadcast := ClientMessage{Action: "3"}
+			broadcast := ClientMessage{Action: StringAction("3")}
 			err = websocket.JSON.Send(conns[1], &broadcast)
 			if err != nil {
 				t.Error(err)
@@ -100,12 +105,13 @@ func TestAuthenticateHandler(t *testing.T) {
 
 	}
 
-	<-exitChan
+	<-done
 }
 
 func TestRequireSecretsIds(t *testing.T) {
-	exitChan := make(chan bool)
-	constructor := func(ids, secrets []string) (websocket.Handler, error) {
+	constructor := func(
+		ids, secrets []string, seed int64,
+	) (websocket.Server, error) {
 		connMan := NewSimpleConnectionManager()
 		state := &mockState{[]int{0, 0}}
 		stateMan := NewSynchronizedStateManager(state, time.Second)
@@ -117,13 +123,14 @@ func TestRequireSecretsIds(t *testing.T) {
 		)
 		recorder := &mockGameRecorder{}
 
-		return GameHandler(
-			exitChan,
+		handler, _ := GameHandler(
+			context.Background(),
 			connMan,
 			clientMan,
 			stateMan,
 			recorder,
-		), nil
+		)
+		return handler, nil
 	}
 
 	_, err := AuthenticateHandler(constructor)
@@ -150,8 +157,9 @@ func TestRequireEqualSecretsIds(t *testing.T) {
 	}
 	defer os.Unsetenv(sandbox.ServerSecretEnvVar)
 
-	exitChan := make(chan bool)
-	constructor := func(ids, secrets []string) (websocket.Handler, error) {
+	constructor := func(
+		ids, secrets []string, seed int64,
+	) (websocket.Server, error) {
 		connMan := NewSimpleConnectionManager()
 		state := &mockState{[]int{0, 0}}
 		stateMan := NewSynchronizedStateManager(state, time.Second)
@@ -163,13 +171,14 @@ func TestRequireEqualSecretsIds(t *testing.T) {
 		)
 		recorder := &mockGameRecorder{}
 
-		return GameHandler(
-			exitChan,
+		handler, _ := GameHandler(
+			context.Background(),
 			connMan,
 			clientMan,
 			stateMan,
 			recorder,
-		), nil
+		)
+		return handler, nil
 	}
 
 	_, err = AuthenticateHandler(constructor)
@@ -0,0 +1,247 @@
+package game
+
+import (
+	"encoding/json"
+	"github.com/crestonbunch/botbox/common/game/replay"
+	"golang.org/x/net/websocket"
+	"log"
+	"sync"
+	"time"
+)
+
+// The path spectators connect to on the game server.
+const SpectatePath = "/spectate"
+
+// How many events a spectator can fall behind before it is disconnected.
+const SpectatorBuffer = 256
+
+const (
+	SpectateSnapshot   = "snapshot"
+	SpectateState      = "state"
+	SpectateResult     = "result"
+	SpectateConnect    = "connect"
+	SpectateDisconnect = "disconnect"
+	SpectateViolation  = "violation"
+	SpectateReconnect  = "reconnect"
+)
+
+// An event broadcast to spectators. The first event every spectator receives
+// is a snapshot of the game so far.
+type SpectatorEvent struct {
+	Type    string          `json:"type"`
+	Client  string          `json:"client,omitempty"`
+	Code    string          `json:"code,omitempty"`
+	Clients []string        `json:"clients,omitempty"`
+	State   json.RawMessage `json:"state,omitempty"`
+	Result  []int           `json:"result,omitempty"`
+	Results []replay.Result `json:"results,omitempty"`
+}
+
+type delayedEvent struct {
+	at    time.Time
+	event SpectatorEvent
+}
+
+// A spectator is a game recorder that broadcasts the game to any number of
+// unauthenticated watchers over websockets. Events can be delayed so that a
+// bot cannot watch the game to learn what its opponents see.
+type Spectator struct {
+	delay    time.Duration
+	mutex    sync.Mutex
+	pending  []delayedEvent
+	signal   chan bool
+	closing  bool
+	snapshot SpectatorEvent
+	watchers map[chan SpectatorEvent]bool
+}
+
+// Create a new spectator that holds back every event for the given delay
+// before broadcasting it.
+func NewSpectator(delay time.Duration) *Spectator {
+	s := &Spectator{
+		delay:    delay,
+		signal:   make(chan bool, 1),
+		snapshot: SpectatorEvent{Type: SpectateSnapshot, Clients: []string{}},
+		watchers: map[chan SpectatorEvent]bool{},
+	}
+	go s.run()
+	return s
+}
+
+// Create a websocket handler that streams the game to a spectator.
+func (s *Spectator) Handler() websocket.Handler {
+	return websocket.Handler(func(conn *websocket.Conn) {
+		defer conn.Close()
+
+		ch := s.watch()
+		for e := range ch {
+			err := websocket.JSON.Send(conn, &e)
+			if err != nil {
+				log.Println("Spectator disconnected: " + err.Error())
+				s.unwatch(ch)
+				return
+			}
+		}
+	})
+}
+
+func (s *Spectator) LogTurn(t Turn) error {
+	s.publish(SpectatorEvent{Type: SpectateState, State: t.State})
+	return nil
+}
+
+func (s *Spectator) LogResult(state GameState) error {
+	s.publish(SpectatorEvent{
+		Type:    SpectateResult,
+		Result:  state.Result(),
+		Results: Rank(state),
+	})
+	return nil
+}
+
+func (s *Spectator) LogConnection(c GameClient) error {
+	s.publish(SpectatorEvent{Type: SpectateConnect, Client: c.Id()})
+	return nil
+}
+
+func (s *Spectator) LogDisconnection(c GameClient) error {
+	s.publish(SpectatorEvent{Type: SpectateDisconnect, Client: c.Id()})
+	return nil
+}
+
+func (s *Spectator) LogReconnection(c GameClient) error {
+	s.publish(SpectatorEvent{Type: SpectateReconnect, Client: c.Id()})
+	return nil
+}
+
+// A client that broke a rule is disconnected, and the code of the violation is
+// passed along.
+func (s *Spectator) LogViolation(c GameClient, v ViolationError) error {
+	s.publish(SpectatorEvent{
+		Type:   SpectateViolation,
+		Client: c.Id(),
+		Code:   v.Code,
+	})
+	return nil
+}
+
+// Stop accepting events. Spectators are disconnected once every pending event
+// has been broadcast.
+func (s *Spectator) Close() error {
+	s.mutex.Lock()
+	s.closing = true
+	s.mutex.Unlock()
+	s.wake()
+	return nil
+}
+
+// Queue an event to be broadcast once the delay is up.
+func (s *Spectator) publish(e SpectatorEvent) {
+	s.mutex.Lock()
+	s.pending = append(s.pending, delayedEvent{time.Now().Add(s.delay), e})
+	s.mutex.Unlock()
+	s.wake()
+}
+
+func (s *Spectator) wake() {
+	select {
+	case s.signal <- true:
+	default:
+	}
+}
+
+// Broadcast events in order as their delays run out.
+func (s *Spectator) run() {
+	for {
+		wait := time.Duration(-1)
+
+		s.mutex.Lock()
+		for len(s.pending) > 0 {
+			if d := time.Until(s.pending[0].at); d > 0 {
+				wait = d
+				break
+			}
+			s.broadcast(s.pending[0].event)
+			s.pending = s.pending[1:]
+		}
+		if s.closing && len(s.pending) == 0 {
+			for ch := range s.watchers {
+				close(ch)
+				delete(s.watchers, ch)
+			}
+			s.mutex.Unlock()
+			return
+		}
+		s.mutex.Unlock()
+
+		if wait < 0 {
+			<-s.signal
+		} else {
+			select {
+			case <-s.signal:
+			case <-time.After(wait):
+			}
+		}
+	}
+}
+
+// Update the snapshot and send an event to every watcher. Watchers that have
+// fallen too far behind are dropped. Must be called holding the mutex.
+func (s *Spectator) broadcast(e SpectatorEvent) {
+	switch e.Type {
+	case SpectateState:
+		s.snapshot.State = e.State
+	case SpectateResult:
+		s.snapshot.Result = e.Result
+		s.snapshot.Results = e.Results
+	case SpectateConnect, SpectateReconnect:
+		s.snapshot.Clients = append(s.snapshot.Clients, e.Client)
+	case SpectateDisconnect, SpectateViolation:
+		clients := []string{}
+		for _, id := range s.snapshot.Clients {
+			if id != e.Client {
+				clients = append(clients, id)
+			}
+		}
+		s.snapshot.Clients = clients
+	}
+
+	for ch := range s.watchers {
+		select {
+		case ch <- e:
+		default:
+			log.Println("Spectator fell behind, dropping")
+			close(ch)
+			delete(s.watchers, ch)
+		}
+	}
+}
+
+// Add a watcher and send it a snapshot of the game so far.
+func (s *Spectator) watch() chan SpectatorEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ch := make(chan SpectatorEvent, SpectatorBuffer)
+	snapshot := s.snapshot
+	snapshot.Clients = append([]string{}, s.snapshot.Clients...)
+	ch <- snapshot
+
+	if s.closing && len(s.pending) == 0 {
+		// the game is already over, there is nothing left to watch
+		close(ch)
+	} else {
+		s.watchers[ch] = true
+	}
+	return ch
+}
+
+func (s *Spectator) unwatch(ch chan SpectatorEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.watchers[ch] {
+		close(ch)
+		delete(s.watchers, ch)
+	}
+}
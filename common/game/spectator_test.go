@@ -0,0 +1,135 @@
+package game
+
+import (
+	"golang.org/x/net/websocket"
+	"testing"
+	"time"
+)
+
+func dialSpectator(t *testing.T, url string) *websocket.Conn {
+	conn, err := websocket.Dial(url, "", "http://localhost/")
+	if err != nil {
+		t.Error(err)
+	}
+	return conn
+}
+
+func receiveEvent(t *testing.T, conn *websocket.Conn) SpectatorEvent {
+	var e SpectatorEvent
+	err := websocket.JSON.Receive(conn, &e)
+	if err != nil {
+		t.Error(err)
+	}
+	return e
+}
+
+func TestSpectator(t *testing.T) {
+	s := NewSpectator(0)
+	url, ts := setupTestServer(s.Handler())
+	defer ts.Close()
+
+	conn := dialSpectator(t, url)
+	defer conn.Close()
+
+	if e := receiveEvent(t, conn); e.Type != SpectateSnapshot {
+		t.Error("Spectator did not receive a snapshot")
+	}
+
+	s.LogConnection(&SynchronizedGameClient{id: "123abc"})
+	s.LogTurn(takeTurn(0, nil, nil, &mockState{[]int{1, 0}}))
+	s.LogDisconnection(&SynchronizedGameClient{id: "123abc"})
+
+	e := receiveEvent(t, conn)
+	if e.Type != SpectateConnect || e.Client != "123abc" {
+		t.Error("Spectator did not receive a connection")
+	}
+	e = receiveEvent(t, conn)
+	if e.Type != SpectateState || string(e.State) != "{\"players\":[1,0]}" {
+		t.Error("Spectator did not receive the state")
+	}
+	e = receiveEvent(t, conn)
+	if e.Type != SpectateDisconnect || e.Client != "123abc" {
+		t.Error("Spectator did not receive a disconnection")
+	}
+
+	s.Close()
+	var e2 SpectatorEvent
+	if err := websocket.JSON.Receive(conn, &e2); err == nil {
+		t.Error("Spectator was not disconnected after the game")
+	}
+}
+
+func TestSpectatorSnapshot(t *testing.T) {
+	s := NewSpectator(0)
+	defer s.Close()
+	url, ts := setupTestServer(s.Handler())
+	defer ts.Close()
+
+	s.LogConnection(&SynchronizedGameClient{id: "123abc"})
+	s.LogConnection(&SynchronizedGameClient{id: "456def"})
+	state := &mockState{[]int{1, 0}}
+	s.LogTurn(takeTurn(0, nil, nil, state))
+	// the state changes after it is logged, but spectators see what it was
+	state.Players[0] = 4
+	s.LogDisconnection(&SynchronizedGameClient{id: "123abc"})
+	time.Sleep(10 * time.Millisecond)
+
+	conn := dialSpectator(t, url)
+	defer conn.Close()
+
+	e := receiveEvent(t, conn)
+	if e.Type != SpectateSnapshot {
+		t.Error("Spectator did not receive a snapshot")
+	}
+	if string(e.State) != "{\"players\":[1,0]}" {
+		t.Error("Snapshot does not have the latest state")
+	}
+	if len(e.Clients) != 1 || e.Clients[0] != "456def" {
+		t.Error("Snapshot does not have the connected clients")
+	}
+}
+
+func TestSpectatorDelay(t *testing.T) {
+	s := NewSpectator(50 * time.Millisecond)
+	defer s.Close()
+	url, ts := setupTestServer(s.Handler())
+	defer ts.Close()
+
+	conn := dialSpectator(t, url)
+	defer conn.Close()
+	receiveEvent(t, conn)
+
+	start := time.Now()
+	s.LogTurn(takeTurn(0, nil, nil, &mockState{[]int{1, 0}}))
+	s.LogTurn(takeTurn(0, nil, nil, &mockState{[]int{2, 0}}))
+
+	e := receiveEvent(t, conn)
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("Spectator received a state before the delay")
+	}
+	if string(e.State) != "{\"players\":[1,0]}" {
+		t.Error("Spectator did not receive states in order")
+	}
+	e = receiveEvent(t, conn)
+	if string(e.State) != "{\"players\":[2,0]}" {
+		t.Error("Spectator did not receive states in order")
+	}
+}
+
+func TestMultiGameRecorder(t *testing.T) {
+	s1 := NewSpectator(0)
+	s2 := NewSpectator(0)
+	r := NewMultiGameRecorder(s1, s2)
+
+	r.LogTurn(takeTurn(0, nil, nil, &mockState{[]int{1, 0}}))
+	r.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	for _, s := range []*Spectator{s1, s2} {
+		ch := s.watch()
+		e := <-ch
+		if string(e.State) != "{\"players\":[1,0]}" {
+			t.Error("Recorder did not receive the state")
+		}
+	}
+}
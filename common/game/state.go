@@ -1,19 +1,61 @@
 package game
 
+import (
+	"encoding/json"
+	"github.com/crestonbunch/botbox/common/game/replay"
+	"log"
+	"time"
+)
+
 const (
 	ResultWin  = 1
 	ResultTie  = 0
 	ResultLoss = -1
 )
 
+// A turn is recorded every time the state changes. It holds the actions that
+// were committed, in player order, how long each player took to choose its
+// action, and the resulting state. Players that were not asked to act during
+// the turn have empty actions.
+//
+// The state keeps changing once the turn is handed over, so it is encoded as
+// JSON when the turn is played. Only the last turn of a match holds the
+// finished state itself in Final, since it does not change anymore.
+type Turn struct {
+	Number  int
+	Actions []json.RawMessage
+	Times   []time.Duration
+	State   json.RawMessage
+	Final   GameState
+}
+
+// Take a turn that was just played, encoding the state before anyone can
+// change it again.
+func takeTurn(
+	number int,
+	actions []json.RawMessage,
+	times []time.Duration,
+	state GameState,
+) Turn {
+	t := Turn{Number: number, Actions: actions, Times: times}
+	b, err := json.Marshal(state)
+	if err != nil {
+		log.Println("Could not encode state: " + err.Error())
+	}
+	t.State = b
+	if state.Finished() {
+		t.Final = state
+	}
+	return t
+}
+
+// A game state must also implement one of StringActor, JSONActor or
+// TypedActor so that actions can be committed with Commit.
 type GameState interface {
 	// Return a JSON-serializable representation of actions that the player p can
 	// make in the current state.
 	Actions(p int) interface{}
 
-	// Commit the given action a (in JSON) for player p.
-	Do(p int, a string)
-
 	// Get a JSON-serializable state of the game, as seen by player p
 	View(p int) interface{}
 
@@ -23,3 +65,23 @@ type GameState interface {
 	// Decide the result of the match. Each player gets a score +1, 0, -1
 	Result() []int
 }
+
+// Games with more than two players, scores or reasons for their results can
+// place every player themselves. The places must agree with Result.
+type Ranker interface {
+	Rank() []replay.Result
+}
+
+// Get the place of every player in a finished game. Games that are not rankers
+// are placed by their wins, ties and losses, and games played in teams are
+// placed by the results of their teams, with the team id of every player.
+func Rank(s GameState) []replay.Result {
+	teams := teamsOf(s)
+	if r, ok := s.(Ranker); ok {
+		return teams.label(r.Rank())
+	}
+	if teams != nil {
+		return teams.Rank(replay.Places(teams.teamResults(s.Result())))
+	}
+	return replay.Places(s.Result())
+}
@@ -0,0 +1,38 @@
+package game
+
+import (
+	"github.com/crestonbunch/botbox/common/game/replay"
+	"strconv"
+	"testing"
+)
+
+// A mock game that scores players by their number.
+type mockRankedState struct {
+	mockState
+}
+
+func (s *mockRankedState) Rank() []replay.Result {
+	results := replay.Places(s.Result())
+	for i := range s.Players {
+		score := float64(s.Players[i])
+		results[i].Score = &score
+		results[i].Reason = "reached " + strconv.Itoa(s.Players[i])
+	}
+	return results
+}
+
+func TestRank(t *testing.T) {
+	results := Rank(&mockState{[]int{12, 4}})
+	if len(results) != 2 || results[0].Place != 1 || results[1].Place != 2 {
+		t.Error("Wins and losses were not placed")
+	}
+	if results[0].Score != nil || results[0].Reason != "" {
+		t.Error("Game without scores was given a score")
+	}
+
+	results = Rank(&mockRankedState{mockState{[]int{4, 12}}})
+	if results[1].Place != 1 || *results[1].Score != 12 ||
+		results[1].Reason != "reached 12" {
+		t.Error("Ranker did not place the players")
+	}
+}
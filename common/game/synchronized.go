@@ -1,7 +1,8 @@
 package game
 
 import (
-	"errors"
+	"context"
+	"encoding/json"
 	"golang.org/x/net/websocket"
 	"log"
 	"sync"
@@ -9,12 +10,32 @@ import (
 )
 
 type SynchronizedGameClient struct {
-	id       string
-	conn     *websocket.Conn
-	watchdog *Watchdog
-	send     chan ServerMessage
-	receive  chan ClientMessage
-	err      chan ClientError
+	id          string
+	conn        *websocket.Conn
+	watchdog    *Watchdog
+	clock       *Clock
+	send        chan ServerMessage
+	receive     chan ClientMessage
+	err         chan ClientError
+	reconnected chan bool
+	mutex       sync.Mutex
+}
+
+// Create a new client for a connection. The client's clock is started with the
+// given time controls.
+func NewSynchronizedGameClient(
+	id string, conn *websocket.Conn, timeout time.Duration, control TimeControl,
+) *SynchronizedGameClient {
+	return &SynchronizedGameClient{
+		id:          id,
+		conn:        conn,
+		watchdog:    NewWatchdog(timeout),
+		clock:       NewClock(control),
+		send:        make(chan ServerMessage),
+		receive:     make(chan ClientMessage),
+		err:         make(chan ClientError),
+		reconnected: make(chan bool, 1),
+	}
 }
 
 func (c *SynchronizedGameClient) Id() string {
@@ -22,13 +43,35 @@ func (c *SynchronizedGameClient) Id() string {
 }
 
 func (c *SynchronizedGameClient) Conn() *websocket.Conn {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	return c.conn
 }
 
+func (c *SynchronizedGameClient) Reconnect(conn *websocket.Conn) {
+	c.mutex.Lock()
+	c.conn = conn
+	c.mutex.Unlock()
+
+	select {
+	case c.reconnected <- true:
+	default:
+	}
+}
+
+func (c *SynchronizedGameClient) Reconnected() chan bool {
+	return c.reconnected
+}
+
 func (c *SynchronizedGameClient) Watchdog() *Watchdog {
 	return c.watchdog
 }
 
+func (c *SynchronizedGameClient) Clock() *Clock {
+	return c.clock
+}
+
 func (c *SynchronizedGameClient) Send() chan ServerMessage {
 	return c.send
 }
@@ -44,35 +87,47 @@ func (c *SynchronizedGameClient) Error() chan ClientError {
 type SynchronizedStateManager struct {
 	state   GameState
 	timeout time.Duration
+	control TimeControl
+	limits  Limits
 }
 
 func NewSynchronizedStateManager(
 	game GameState, timeout time.Duration,
 ) *SynchronizedStateManager {
-	return &SynchronizedStateManager{game, timeout}
+	return &SynchronizedStateManager{
+		game, timeout, FixedTimeControl(timeout), DefaultLimits(),
+	}
+}
+
+// Play the game with the given time controls instead of a fixed timeout for
+// every move. Must be called before any clients are created.
+func (m *SynchronizedStateManager) SetTimeControl(control TimeControl) {
+	m.control = control
+}
+
+// Stop the match at the given limits instead of the default ones.
+func (m *SynchronizedStateManager) SetLimits(limits Limits) {
+	m.limits = limits
 }
 
 func (m *SynchronizedStateManager) NewClient(
 	id string, conn *websocket.Conn,
 ) GameClient {
-	return &SynchronizedGameClient{
-		id,
-		conn,
-		NewWatchdog(m.timeout),
-		make(chan ServerMessage),
-		make(chan ClientMessage),
-		make(chan ClientError),
-	}
+	return NewSynchronizedGameClient(id, conn, m.timeout, m.control)
 }
 
-// Synchronizes gameplay so both players make moves at the same time. If a
-// player does not make a move in the allotted timeframe, then it its turn
-// is skipped and a timeout error is sent along the error channel. Game states
-// may punish a client by doing something if the action received is the empty
-// string.
+// Synchronizes gameplay so all players make moves at the same time. Every
+// player is sent the state at once and given its own watchdog to think
+// concurrently. The turn ends when every player has responded or timed out.
+// If a player does not make a move in the time its clock allows, then it its
+// turn is skipped and a timeout error is sent along the error channel. Game
+// states may punish a client by doing something if the action received is the
+// empty string. Stops between turns when the context is done, or when a limit
+// is reached, in which case the last turn holds the adjudicated state.
 func (m *SynchronizedStateManager) Play(
+	ctx context.Context,
 	clients []GameClient,
-	stateChan chan GameState,
+	turnChan chan Turn,
 	errChan chan error,
 ) *sync.WaitGroup {
 
@@ -80,39 +135,48 @@ func (m *SynchronizedStateManager) Play(
 	wg.Add(1)
 
 	go func() {
-		for !m.state.Finished() {
+		eliminated := make([]bool, len(clients))
+		started := time.Now()
+		for turn := 0; !m.state.Finished() && ctx.Err() == nil; turn++ {
 			// wait for actions from every player to commit them simultaneously
-			actions := make([]string, len(clients))
-			// block for all players and queue up their actions
+			actions := make([]json.RawMessage, len(clients))
+			times := make([]time.Duration, len(clients))
+			// build every message before anyone can act so that all players see
+			// the same state
+			messages := make([]ServerMessage, len(clients))
+			for i, c := range clients {
+				messages[i] = stateMessage(m.state, c, turn, i)
+			}
+
+			var pending sync.WaitGroup
+			pending.Add(len(clients))
 			for i, c := range clients {
-				watchCh := c.Watchdog().Watch()
-				log.Println("Sending message to client " + c.Id())
-				// note that if an error is returned, then the action will be the empty
-				// string, so a state can kill a player if the empty string is received
-				// to punish bad players
-				select {
-				case c.Send() <- ServerMessage{i, m.state.Actions(i), m.state.View(i)}:
-				case <-watchCh:
-					errChan <- errors.New("Client send timeout")
-				}
-
-				select {
-				case msg := <-c.Receive():
-					actions[i] = msg.Action
-				case err := <-c.Error():
-					errChan <- err
-				case <-watchCh:
-					errChan <- errors.New("Client receive timeout")
-				}
-				c.Watchdog().Stop()
-				log.Println("Got action '" + actions[i] + "' from client " + c.Id())
+				go func(i int, c GameClient) {
+					defer pending.Done()
+					actions[i], times[i] = request(ctx, c, messages[i], errChan)
+				}(i, c)
+			}
+			// block for all players and queue up their actions
+			pending.Wait()
+
+			// commit actions simultaneously in player order
+			if ctx.Err() != nil {
+				// the game was aborted while players were thinking
+				break
 			}
-			// commit actions simultaneously
 			for i, a := range actions {
-				m.state.Do(i, a)
+				commit(ctx, m.state, i, clients[i], a, turn, errChan)
+			}
+			notifyEliminated(m.state, clients, turn, eliminated)
+			state, stop := m.limits.check(m.state, len(clients), turn, started)
+			select {
+			case turnChan <- takeTurn(turn, actions, times, state):
+			case <-ctx.Done():
 			}
-			stateChan <- m.state
 			log.Println("Committed actions.")
+			if stop {
+				break
+			}
 		}
 
 		wg.Done()
@@ -1,22 +1,27 @@
 package game
 
 import (
+	"context"
 	"golang.org/x/net/websocket"
+	"io"
+	"io/ioutil"
 	"math"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
 
 func TestSynchronizedStateManager(t *testing.T) {
 	state := &mockState{[]int{0, 0}}
-	stateChan := make(chan GameState)
+	turnChan := make(chan Turn)
 	errChan := make(chan error)
 	stateMan := NewSynchronizedStateManager(state, time.Millisecond)
 
-	url, ts := setupTestServer(func(conn *websocket.Conn) {
-		for {
-		}
-	})
+	// the server never answers, and stops once the client hangs up
+	url, ts := setupTestServer(websocket.Handler(func(conn *websocket.Conn) {
+		io.Copy(ioutil.Discard, conn)
+	}))
 	defer ts.Close()
 	origin := "http://localhost/"
 
@@ -36,17 +41,17 @@ func TestSynchronizedStateManager(t *testing.T) {
 		stateMan.NewClient("2", conns[1]),
 	}
 
-	wg := stateMan.Play(clients, stateChan, errChan)
+	wg := stateMan.Play(context.Background(), clients, turnChan, errChan)
 
 	go func() {
 		for i := 0; i < 4; i++ {
 			// make move
 			<-clients[0].Send()
-			clients[0].Receive() <- ClientMessage{"1"}
+			clients[0].Receive() <- ClientMessage{StringAction("1")}
 			<-clients[1].Send()
-			clients[1].Receive() <- ClientMessage{"3"}
+			clients[1].Receive() <- ClientMessage{StringAction("3")}
 			select {
-			case <-stateChan:
+			case <-turnChan:
 			case err := <-errChan:
 				t.Error(err)
 			}
@@ -69,6 +74,172 @@ func TestSynchronizedStateManager(t *testing.T) {
 	}
 }
 
+func TestSynchronizedLimits(t *testing.T) {
+	state := &mockState{[]int{0, 0}}
+	turnChan := make(chan Turn)
+	errChan := make(chan error)
+	stateMan := NewSynchronizedStateManager(state, time.Second)
+	stateMan.SetLimits(Limits{Turns: 2})
+
+	clients := []GameClient{
+		stateMan.NewClient("1", nil),
+		stateMan.NewClient("2", nil),
+	}
+
+	wg := stateMan.Play(context.Background(), clients, turnChan, errChan)
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			<-clients[0].Send()
+			clients[0].Receive() <- ClientMessage{StringAction("1")}
+			<-clients[1].Send()
+			clients[1].Receive() <- ClientMessage{StringAction("1")}
+		}
+	}()
+
+	<-turnChan
+	last := <-turnChan
+	wg.Wait()
+
+	a, ok := last.Final.(*AdjudicatedState)
+	if !ok || a.Reason != AdjudicatedTurns {
+		t.Fatal("Game was not adjudicated at the turn limit")
+	}
+	if state.Finished() || !a.Finished() {
+		t.Error("Game was not stopped before it finished")
+	}
+}
+
+func TestSynchronizedTurnSnapshot(t *testing.T) {
+	state := &mockState{[]int{0, 0}}
+	turnChan := make(chan Turn)
+	errChan := make(chan error)
+	stateMan := NewSynchronizedStateManager(state, time.Second)
+
+	clients := []GameClient{
+		stateMan.NewClient("1", nil),
+		stateMan.NewClient("2", nil),
+	}
+
+	wg := stateMan.Play(context.Background(), clients, turnChan, errChan)
+
+	go func() {
+		for i := 0; i < 4; i++ {
+			<-clients[0].Send()
+			clients[0].Receive() <- ClientMessage{StringAction("1")}
+			<-clients[1].Send()
+			clients[1].Receive() <- ClientMessage{StringAction("3")}
+		}
+	}()
+
+	turns := []Turn{}
+	for i := 0; i < 4; i++ {
+		turns = append(turns, <-turnChan)
+	}
+	wg.Wait()
+
+	// every turn keeps the state it left behind, long after it has changed
+	for i, turn := range turns {
+		expected := "{\"players\":[" + strconv.Itoa(i+1) + "," +
+			strconv.Itoa(3*(i+1)) + "]}"
+		if string(turn.State) != expected {
+			t.Errorf("Turn %d state is %s", i, turn.State)
+		}
+	}
+	if turns[2].Final != nil {
+		t.Error("Turn before the end has a final state")
+	}
+	if turns[3].Final != state {
+		t.Error("Last turn does not have the final state")
+	}
+}
+
+func TestSynchronizedParallelRequests(t *testing.T) {
+	state := &mockState{[]int{0, 0}}
+	turnChan := make(chan Turn)
+	errChan := make(chan error)
+	stateMan := NewSynchronizedStateManager(state, 100*time.Millisecond)
+
+	clients := []GameClient{
+		stateMan.NewClient("1", nil),
+		stateMan.NewClient("2", nil),
+	}
+
+	wg := stateMan.Play(context.Background(), clients, turnChan, errChan)
+
+	go func() {
+		for i := 0; i < 4; i++ {
+			// both players must see the state before either of them responds, in
+			// reverse order to make sure nobody is waiting on player 1
+			<-clients[1].Send()
+			<-clients[0].Send()
+			clients[1].Receive() <- ClientMessage{StringAction("3")}
+			clients[0].Receive() <- ClientMessage{StringAction("1")}
+			select {
+			case <-turnChan:
+			case err := <-errChan:
+				t.Error(err)
+				<-turnChan
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	if state.Players[0] != 4 {
+		t.Error("Player 1 score is not 4")
+	}
+	if state.Players[1] != 12 {
+		t.Error("Player 2 score is not 12")
+	}
+}
+
+func TestSynchronizedConcurrentTimeouts(t *testing.T) {
+	state := &mockState{[]int{0, 0}}
+	turnChan := make(chan Turn)
+	errChan := make(chan error)
+	stateMan := NewSynchronizedStateManager(state, 200*time.Millisecond)
+
+	clients := []GameClient{
+		stateMan.NewClient("1", nil),
+		stateMan.NewClient("2", nil),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg := stateMan.Play(ctx, clients, turnChan, errChan)
+
+	// Player 1 never responds and player 2 takes almost as long as the timeout
+	// to respond, so each turn should only take as long as a single timeout.
+	// The reply is sent aside, since it is too late when the test runs slowly,
+	// and the errors of the turn must still be read.
+	go func() {
+		for {
+			select {
+			case <-clients[0].Send():
+			case <-clients[1].Send():
+				go func() {
+					time.Sleep(160 * time.Millisecond)
+					select {
+					case clients[1].Receive() <- ClientMessage{StringAction("3")}:
+					case <-ctx.Done():
+					}
+				}()
+			case <-errChan:
+			case <-turnChan:
+			}
+		}
+	}()
+
+	start := time.Now()
+	wg.Wait()
+	duration := time.Since(start)
+
+	if duration >= 4*320*time.Millisecond {
+		t.Error("Players did not think concurrently")
+	}
+}
+
 func TestSynchronizedGameHandler(t *testing.T) {
 	ids := []string{"id1", "id2"}
 	secrets := []string{"secret1", "secret2"}
@@ -83,10 +254,9 @@ func TestSynchronizedGameHandler(t *testing.T) {
 		time.Second,
 	)
 	recorder := &mockGameRecorder{}
-	exitChan := make(chan bool)
 
-	handler := GameHandler(
-		exitChan,
+	handler, done := GameHandler(
+		context.Background(),
 		connMan,
 		clientMan,
 		stateMan,
@@ -119,7 +289,7 @@ func TestSynchronizedGameHandler(t *testing.T) {
 			if err != nil {
 				t.Error(err)
 			}
-			broadcast := ClientMessage{Action: "1"}
+			broadcast := ClientMessage{Action: StringAction("1")}
 			err = websocket.JSON.Send(conns[0], &broadcast)
 			if err != nil {
 				t.Error(err)
@@ -132,7 +302,7 @@ func TestSynchronizedGameHandler(t *testing.T) {
 			if err != nil {
 				t.Error(err)
 			}
-			broadcast := ClientMessage{Action: "3"}
+			broadcast := ClientMessage{Action: StringAction("3")}
 			err = websocket.JSON.Send(conns[1], &broadcast)
 			if err != nil {
 				t.Error(err)
@@ -140,7 +310,7 @@ func TestSynchronizedGameHandler(t *testing.T) {
 		}()
 	}
 
-	<-exitChan
+	<-done
 
 	if !state.Finished() {
 		t.Error("Game did not finish!")
@@ -174,13 +344,12 @@ func TestSynchronizedBadAgentConnectionTimeout(t *testing.T) {
 		stateMan.NewClient,
 		ids,
 		secrets,
-		2*time.Millisecond,
+		100*time.Millisecond,
 	)
 	recorder := &mockGameRecorder{}
-	exitChan := make(chan bool)
 
-	handler := GameHandler(
-		exitChan,
+	handler, done := GameHandler(
+		context.Background(),
 		connMan,
 		clientMan,
 		stateMan,
@@ -208,7 +377,7 @@ func TestSynchronizedBadAgentConnectionTimeout(t *testing.T) {
 		conns = append(conns, conn)
 	}
 
-	<-exitChan
+	<-done
 
 	duration := time.Since(start)
 
@@ -221,8 +390,8 @@ func TestSynchronizedBadAgentConnectionTimeout(t *testing.T) {
 	}
 
 	// TODO: this is a janky way of testing timeouts
-	if math.Abs(float64(duration-2*time.Millisecond)) < 0.1 {
-		t.Error("Client manager did not timeout in 1 ms")
+	if math.Abs(float64(duration-100*time.Millisecond)) < 0.1 {
+		t.Error("Client manager did not timeout in 100 ms")
 	}
 }
 
@@ -237,13 +406,12 @@ func TestSynchronizedHangOnReceive(t *testing.T) {
 		stateMan.NewClient,
 		ids,
 		secrets,
-		2*time.Millisecond,
+		time.Second,
 	)
 	recorder := &mockGameRecorder{}
-	exitChan := make(chan bool)
 
-	handler := GameHandler(
-		exitChan,
+	handler, done := GameHandler(
+		context.Background(),
 		connMan,
 		clientMan,
 		stateMan,
@@ -284,7 +452,7 @@ func TestSynchronizedHangOnReceive(t *testing.T) {
 			if err != nil {
 				t.Error(err)
 			}
-			broadcast := ClientMessage{Action: "3"}
+			broadcast := ClientMessage{Action: StringAction("3")}
 			err = websocket.JSON.Send(conns[1], &broadcast)
 			if err != nil {
 				t.Error(err)
@@ -292,7 +460,7 @@ func TestSynchronizedHangOnReceive(t *testing.T) {
 		}()
 	}
 
-	<-exitChan
+	<-done
 	if !state.Finished() {
 		t.Error("Game did not finish!")
 	}
@@ -324,13 +492,12 @@ func TestSynchronizedBadAgentDisconnected(t *testing.T) {
 		stateMan.NewClient,
 		ids,
 		secrets,
-		2*time.Millisecond,
+		time.Second,
 	)
 	recorder := &mockGameRecorder{}
-	exitChan := make(chan bool)
 
-	handler := GameHandler(
-		exitChan,
+	handler, done := GameHandler(
+		context.Background(),
 		connMan,
 		clientMan,
 		stateMan,
@@ -356,27 +523,22 @@ func TestSynchronizedBadAgentDisconnected(t *testing.T) {
 	}
 
 	// Simulate two players
-	counter := 0
-	for i := 0; i < 2; i++ {
-		go func() {
+	go func() {
+		for i := 0; i < 2; i++ {
 			var msg ServerMessage
 			err := websocket.JSON.Receive(conns[0], &msg)
 			if err != nil {
 				t.Error(err)
 			}
-			broadcast := ClientMessage{Action: "1"}
+			broadcast := ClientMessage{Action: StringAction("1")}
 			err = websocket.JSON.Send(conns[0], &broadcast)
 			if err != nil {
 				t.Error(err)
 			}
-			// Player 1 disconnects after 2 turns
-			if counter > 0 {
-				conns[0].Close()
-				return
-			}
-			counter++
-		}()
-	}
+		}
+		// Player 1 disconnects after 2 turns
+		conns[0].Close()
+	}()
 
 	for i := 0; i < 4; i++ {
 		go func() {
@@ -385,7 +547,7 @@ func TestSynchronizedBadAgentDisconnected(t *testing.T) {
 			if err != nil {
 				t.Error(err)
 			}
-			broadcast := ClientMessage{Action: "3"}
+			broadcast := ClientMessage{Action: StringAction("3")}
 			err = websocket.JSON.Send(conns[1], &broadcast)
 			if err != nil {
 				t.Error(err)
@@ -393,7 +555,7 @@ func TestSynchronizedBadAgentDisconnected(t *testing.T) {
 		}()
 	}
 
-	<-exitChan
+	<-done
 	if !state.Finished() {
 		t.Error("Game did not finish!")
 	}
@@ -428,10 +590,9 @@ func TestSynchronizedAttemptedCheater(t *testing.T) {
 		time.Second,
 	)
 	recorder := &mockGameRecorder{}
-	exitChan := make(chan bool)
 
-	handler := GameHandler(
-		exitChan,
+	handler, done := GameHandler(
+		context.Background(),
 		connMan,
 		clientMan,
 		stateMan,
@@ -467,7 +628,7 @@ func TestSynchronizedAttemptedCheater(t *testing.T) {
 			// Player 1 tries to cheat by sending 10 actions in a row, but it should
 			// not let him get away with that
 			for i := 0; i < 10; i++ {
-				broadcast := ClientMessage{Action: "1"}
+				broadcast := ClientMessage{Action: StringAction("1")}
 				websocket.JSON.Send(conns[0], &broadcast)
 			}
 		}()
@@ -478,7 +639,7 @@ func TestSynchronizedAttemptedCheater(t *testing.T) {
 			if err != nil {
 				t.Error(err)
 			}
-			broadcast := ClientMessage{Action: "3"}
+			broadcast := ClientMessage{Action: StringAction("3")}
 			err = websocket.JSON.Send(conns[1], &broadcast)
 			if err != nil {
 				t.Error(err)
@@ -486,7 +647,7 @@ func TestSynchronizedAttemptedCheater(t *testing.T) {
 		}()
 	}
 
-	<-exitChan
+	<-done
 
 	if !state.Finished() {
 		t.Error("Game did not finish!")
@@ -515,7 +676,7 @@ func TestSynchronizedUselessAgent(t *testing.T) {
 
 	connMan := NewSimpleConnectionManager()
 	state := &mockState{[]int{0, 0}}
-	stateMan := NewSynchronizedStateManager(state, 2*time.Millisecond)
+	stateMan := NewSynchronizedStateManager(state, 100*time.Millisecond)
 	clientMan := NewAuthenticatedClientManager(
 		stateMan.NewClient,
 		ids,
@@ -523,10 +684,9 @@ func TestSynchronizedUselessAgent(t *testing.T) {
 		time.Second,
 	)
 	recorder := &mockGameRecorder{}
-	exitChan := make(chan bool)
 
-	handler := GameHandler(
-		exitChan,
+	handler, done := GameHandler(
+		context.Background(),
 		connMan,
 		clientMan,
 		stateMan,
@@ -563,7 +723,7 @@ func TestSynchronizedUselessAgent(t *testing.T) {
 			if err != nil {
 				t.Error(err)
 			}
-			broadcast := ClientMessage{Action: "3"}
+			broadcast := ClientMessage{Action: StringAction("3")}
 			err = websocket.JSON.Send(conns[1], &broadcast)
 			if err != nil {
 				t.Error(err)
@@ -571,7 +731,7 @@ func TestSynchronizedUselessAgent(t *testing.T) {
 		}()
 	}
 
-	<-exitChan
+	<-done
 
 	if !state.Finished() {
 		t.Error("Game did not finish!")
@@ -593,3 +753,264 @@ func TestSynchronizedUselessAgent(t *testing.T) {
 	}
 
 }
+
+func TestSynchronizedReconnect(t *testing.T) {
+	ids := []string{"id1", "id2"}
+	secrets := []string{"secret1", "secret2"}
+
+	connMan := NewSimpleConnectionManager()
+	state := &mockState{[]int{0, 0}}
+	stateMan := NewSynchronizedStateManager(state, time.Second)
+	clientMan := NewAuthenticatedClientManager(
+		stateMan.NewClient,
+		ids,
+		secrets,
+		time.Second,
+	)
+	clientMan.SetReconnectGrace(time.Second)
+	recorder := &mockGameRecorder{}
+
+	handler, done := GameHandler(
+		context.Background(),
+		connMan,
+		clientMan,
+		stateMan,
+		recorder,
+	)
+
+	url, ts := setupTestServer(handler)
+	defer ts.Close()
+	origin := "http://localhost/"
+	dial := func(secret string) *websocket.Conn {
+		config, err := websocket.NewConfig(url, origin)
+		if err != nil {
+			t.Error(err)
+		}
+		config.Header.Add("Authorization", secret)
+		conn, err := websocket.DialConfig(config)
+		if err != nil {
+			t.Error(err)
+		}
+		return conn
+	}
+	conns := []*websocket.Conn{dial(secrets[0]), dial(secrets[1])}
+	defer conns[1].Close()
+
+	// Player 1 drops its connection after receiving the first state, then
+	// reconnects and is sent the same state again.
+	reconnected := make(chan *websocket.Conn, 1)
+	go func() {
+		var msg ServerMessage
+		err := websocket.JSON.Receive(conns[0], &msg)
+		if err != nil {
+			t.Error(err)
+		}
+		conns[0].Close()
+		time.Sleep(50 * time.Millisecond)
+
+		conn := dial(secrets[0])
+		reconnected <- conn
+		for i := 0; i < 4; i++ {
+			var msg ServerMessage
+			err := websocket.JSON.Receive(conn, &msg)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			broadcast := ClientMessage{Action: StringAction("1")}
+			err = websocket.JSON.Send(conn, &broadcast)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	go func() {
+		for i := 0; i < 4; i++ {
+			var msg ServerMessage
+			err := websocket.JSON.Receive(conns[1], &msg)
+			if err != nil {
+				t.Error(err)
+			}
+			broadcast := ClientMessage{Action: StringAction("3")}
+			err = websocket.JSON.Send(conns[1], &broadcast)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	<-done
+	conn := <-reconnected
+	defer conn.Close()
+
+	if state.Players[0] != 4 {
+		t.Error("Player 1 score is not 4")
+	}
+	if state.Players[1] != 12 {
+		t.Error("Player 2 score is not 12")
+	}
+	if len(recorder.disconnections) != 1 || recorder.disconnections[0] != "id1" {
+		t.Error("Disconnection was not recorded")
+	}
+	if len(recorder.reconnections) != 1 || recorder.reconnections[0] != "id1" {
+		t.Error("Reconnection was not recorded")
+	}
+}
+
+func TestSynchronizedReconnectGraceOver(t *testing.T) {
+	connChan := make(chan *websocket.Conn)
+	ids := []string{"id1"}
+	secrets := []string{"secret1"}
+	stateMan := NewSynchronizedStateManager(&mockState{[]int{0}}, time.Second)
+	m := NewAuthenticatedClientManager(
+		stateMan.NewClient,
+		ids,
+		secrets,
+		time.Second,
+	)
+	m.SetReconnectGrace(time.Millisecond)
+
+	url, ts := setupTestServer(websocket.Handler(func(conn *websocket.Conn) {
+		connChan <- conn
+		// keep the connection open
+		<-time.After(time.Second)
+	}))
+	defer ts.Close()
+
+	wg := m.Register(context.Background(), connChan)
+	config, _ := websocket.NewConfig(url, "http://localhost/")
+	config.Header.Add("Authorization", secrets[0])
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Error(err)
+	}
+	defer conn.Close()
+	wg.Wait()
+
+	// a client that is still connected can't connect twice
+	conn2, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Error(err)
+	}
+	defer conn2.Close()
+
+	m.Disconnected(m.Clients()[0])
+	time.Sleep(10 * time.Millisecond)
+
+	// too late to reconnect
+	conn3, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Error(err)
+	}
+	defer conn3.Close()
+
+	select {
+	case <-m.Reconnections():
+		t.Error("Client reconnected when it should not have")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSynchronizedBannedReconnect(t *testing.T) {
+	ids := []string{"id1", "id2"}
+	secrets := []string{"secret1", "secret2"}
+
+	connMan := NewSimpleConnectionManager()
+	state := &mockState{[]int{0, 0}}
+	stateMan := NewSynchronizedStateManager(state, 200*time.Millisecond)
+	clientMan := NewAuthenticatedClientManager(
+		stateMan.NewClient,
+		ids,
+		secrets,
+		time.Second,
+	)
+	clientMan.SetReconnectGrace(time.Second)
+	recorder := &mockGameRecorder{}
+
+	handler, done := GameHandler(
+		context.Background(),
+		connMan,
+		clientMan,
+		stateMan,
+		recorder,
+	)
+
+	url, ts := setupTestServer(handler)
+	defer ts.Close()
+	origin := "http://localhost/"
+	dial := func(secret string) *websocket.Conn {
+		config, err := websocket.NewConfig(url, origin)
+		if err != nil {
+			t.Error(err)
+		}
+		config.Header.Add("Authorization", secret)
+		conn, err := websocket.DialConfig(config)
+		if err != nil {
+			t.Error(err)
+		}
+		return conn
+	}
+	conns := []*websocket.Conn{dial(secrets[0]), dial(secrets[1])}
+	defer conns[0].Close()
+	defer conns[1].Close()
+
+	// Player 1 sends an action that is too large and is banned, and then tries
+	// to come back with its secret, but is hung up on.
+	rejected := make(chan bool, 1)
+	go func() {
+		var msg ServerMessage
+		err := websocket.JSON.Receive(conns[0], &msg)
+		if err != nil {
+			t.Error(err)
+		}
+		action := StringAction(strings.Repeat("1", MaxMessageSize))
+		err = websocket.JSON.Send(conns[0], &ClientMessage{Action: action})
+		if err != nil {
+			t.Error(err)
+		}
+		for msg.Type != MessageError {
+			err := websocket.JSON.Receive(conns[0], &msg)
+			if err != nil {
+				t.Error(err)
+				break
+			}
+		}
+
+		conn := dial(secrets[0])
+		defer conn.Close()
+		err = websocket.JSON.Receive(conn, &msg)
+		rejected <- err != nil
+	}()
+
+	go func() {
+		for i := 0; i < 4; i++ {
+			var msg ServerMessage
+			err := websocket.JSON.Receive(conns[1], &msg)
+			if err != nil {
+				t.Error(err)
+			}
+			broadcast := ClientMessage{Action: StringAction("3")}
+			err = websocket.JSON.Send(conns[1], &broadcast)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	if !<-rejected {
+		t.Error("Banned client was let back in")
+	}
+	<-done
+
+	if len(recorder.violations) != 1 ||
+		recorder.violations[0] != "id1 "+ErrorMessageTooLarge {
+		t.Error("Violation was not recorded")
+	}
+	if len(recorder.reconnections) != 0 {
+		t.Error("Reconnection of a banned client was recorded")
+	}
+	if state.Players[1] != 12 {
+		t.Error("Player 2 score is not 12")
+	}
+}
@@ -0,0 +1,175 @@
+package game
+
+import (
+	"errors"
+	"github.com/crestonbunch/botbox/common/game/replay"
+)
+
+// The teams of a match, as the id of the team of every player in player order,
+// e.g., {"red", "blue", "red", "blue"} for a 2v2 match. Players are in the
+// order of the ids of the match, not the order they connect in. Teammates share
+// their result. Matches that are not played in teams have nil teams.
+type Teams []string
+
+// Check that the teams fit a match between the given number of players.
+func (t Teams) check(players int) error {
+	if len(t) != players {
+		return errors.New("Every player needs a team.")
+	}
+	for _, id := range t {
+		if id == "" {
+			return errors.New("Team ids cannot be empty.")
+		}
+	}
+	if len(t.Ids()) < 2 {
+		return errors.New("A match needs at least two teams.")
+	}
+	return nil
+}
+
+// Return the id of every team, in the order teams first appear among the
+// players. Teams are numbered by their index in this list.
+func (t Teams) Ids() []string {
+	ids := []string{}
+	seen := map[string]bool{}
+	for _, id := range t {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Return the number of the team of player p.
+func (t Teams) Team(p int) int {
+	for i, id := range t.Ids() {
+		if id == t[p] {
+			return i
+		}
+	}
+	return -1
+}
+
+// Return the players on a team in player order.
+func (t Teams) Members(team int) []int {
+	id := t.Ids()[team]
+	members := []int{}
+	for p := range t {
+		if t[p] == id {
+			members = append(members, p)
+		}
+	}
+	return members
+}
+
+// Return the other players on the team of player p, so that games can share
+// what they know in the view of p.
+func (t Teams) Teammates(p int) []int {
+	teammates := []int{}
+	for q := range t {
+		if q != p && t[q] == t[p] {
+			teammates = append(teammates, q)
+		}
+	}
+	return teammates
+}
+
+// Give every player the result of its team. The results of the teams are given
+// in team order.
+func (t Teams) Result(teamResults []int) []int {
+	result := make([]int, len(t))
+	for p := range t {
+		result[p] = teamResults[t.Team(p)]
+	}
+	return result
+}
+
+// Give every player the place, score and reason of its team, along with the
+// team id. The results of the teams are given in team order.
+func (t Teams) Rank(teamResults []replay.Result) []replay.Result {
+	results := make([]replay.Result, len(t))
+	for p := range t {
+		results[p] = teamResults[t.Team(p)]
+		results[p].Team = t[p]
+	}
+	return results
+}
+
+// Return a copy of player results with the team id of every player.
+func (t Teams) label(results []replay.Result) []replay.Result {
+	if t == nil {
+		return results
+	}
+
+	labeled := make([]replay.Result, len(results))
+	copy(labeled, results)
+	for p := range labeled {
+		if p < len(t) {
+			labeled[p].Team = t[p]
+		}
+	}
+	return labeled
+}
+
+// Return the result of every team, given the result of every player, which
+// teammates share.
+func (t Teams) teamResults(result []int) []int {
+	teamResults := make([]int, len(t.Ids()))
+	for i := range teamResults {
+		teamResults[i] = result[t.Members(i)[0]]
+	}
+	return teamResults
+}
+
+// Games that can be played in teams are told the teams of a match before it
+// starts, so that views can share what teammates know, and so that results can
+// be decided per team and given to every member with Teams.Result or
+// Teams.Rank. Only these games can be played in teams.
+type TeamGameState interface {
+	GameState
+	SetTeams(teams Teams)
+	Teams() Teams
+}
+
+// Embed TeamPlay in a game state to keep the teams of its match and implement
+// TeamGameState. The teams are nil if the match is not played in teams.
+type TeamPlay struct {
+	teams Teams
+}
+
+func (t *TeamPlay) SetTeams(teams Teams) {
+	t.teams = teams
+}
+
+func (t *TeamPlay) Teams() Teams {
+	return t.teams
+}
+
+// Tell a game state the teams of a match between the given number of players
+// before it starts. Does nothing if the teams are nil. Returns an error if the
+// game can not be played in teams, or the teams do not fit the match.
+func SetTeams(s GameState, players int, teams Teams) error {
+	if teams == nil {
+		return nil
+	}
+	t, ok := s.(TeamGameState)
+	if !ok {
+		return errors.New("Game cannot be played in teams.")
+	}
+	err := teams.check(players)
+	if err != nil {
+		return err
+	}
+
+	t.SetTeams(teams)
+	return nil
+}
+
+// Return the teams of a game state, or nil if it is not played in teams.
+func teamsOf(s GameState) Teams {
+	if t, ok := s.(TeamGameState); ok {
+		return t.Teams()
+	}
+	return nil
+}
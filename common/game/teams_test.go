@@ -0,0 +1,176 @@
+package game
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/crestonbunch/botbox/common/game/replay"
+	"reflect"
+	"testing"
+)
+
+// A mock game played in teams, where a team wins if any of its players is
+// ahead.
+type mockTeamState struct {
+	mockState
+	TeamPlay
+}
+
+func (s *mockTeamState) Result() []int {
+	teams := s.Teams()
+	teamResults := make([]int, len(teams.Ids()))
+	for i := range teamResults {
+		teamResults[i] = ResultLoss
+	}
+	for p, r := range s.mockState.Result() {
+		if r != ResultLoss {
+			teamResults[teams.Team(p)] = ResultWin
+		}
+	}
+	return teams.Result(teamResults)
+}
+
+// A mock game played in teams that gives the win to whoever is ahead when it
+// is stopped.
+type mockTeamAdjudicatorState struct {
+	mockAdjudicatorState
+	TeamPlay
+}
+
+func TestTeams(t *testing.T) {
+	teams := Teams{"red", "blue", "red", "green"}
+	if !reflect.DeepEqual(teams.Ids(), []string{"red", "blue", "green"}) {
+		t.Error("Team ids are not in the order they appear")
+	}
+	if teams.Team(2) != 0 || teams.Team(3) != 2 {
+		t.Error("Players were not found on their team")
+	}
+	if !reflect.DeepEqual(teams.Members(0), []int{0, 2}) {
+		t.Error("Team members were not found")
+	}
+	if !reflect.DeepEqual(teams.Teammates(2), []int{0}) ||
+		len(teams.Teammates(1)) != 0 {
+		t.Error("Teammates were not found")
+	}
+
+	result := teams.Result([]int{ResultLoss, ResultWin, ResultLoss})
+	if !reflect.DeepEqual(result, []int{-1, 1, -1, -1}) {
+		t.Error("Team results were not given to every member")
+	}
+	results := teams.Rank(replay.Places([]int{ResultLoss, ResultWin, ResultLoss}))
+	if results[2].Place != 2 || results[2].Team != "red" ||
+		results[1].Place != 1 || results[1].Team != "blue" {
+		t.Error("Team places were not given to every member")
+	}
+}
+
+func TestSetTeams(t *testing.T) {
+	state := &mockTeamState{mockState: mockState{make([]int, 4)}}
+	cases := []struct {
+		teams Teams
+		err   string
+	}{
+		{Teams{"red", "blue", "red"}, "Every player needs a team."},
+		{Teams{"red", "", "red", "blue"}, "Team ids cannot be empty."},
+		{Teams{"red", "red", "red", "red"}, "A match needs at least two teams."},
+	}
+	for _, c := range cases {
+		err := SetTeams(state, 4, c.teams)
+		if err == nil || err.Error() != c.err {
+			t.Error("Teams ", c.teams, " were not rejected with ", c.err, ": ", err)
+		}
+	}
+	if state.Teams() != nil {
+		t.Error("Rejected teams were set")
+	}
+
+	err := SetTeams(&mockState{make([]int, 2)}, 2, Teams{"red", "blue"})
+	if err == nil || err.Error() != "Game cannot be played in teams." {
+		t.Error("Game without teams was played in teams")
+	}
+	if SetTeams(&mockState{make([]int, 2)}, 2, nil) != nil {
+		t.Error("Game without teams could not be played without them")
+	}
+
+	teams := Teams{"red", "blue", "red", "blue"}
+	err = SetTeams(state, 4, teams)
+	if err != nil || !reflect.DeepEqual(state.Teams(), teams) {
+		t.Error("Teams were not set: ", err)
+	}
+}
+
+func TestRankTeams(t *testing.T) {
+	state := &mockTeamState{mockState: mockState{[]int{2, 5, 12, 1}}}
+	state.SetTeams(Teams{"red", "blue", "red", "blue"})
+
+	if !reflect.DeepEqual(state.Result(), []int{1, -1, 1, -1}) {
+		t.Error("Teammates do not share the result of their team")
+	}
+	results := Rank(state)
+	if results[0].Place != 1 || results[1].Place != 2 ||
+		results[2].Place != 1 || results[3].Place != 2 {
+		t.Error("Teams were not placed")
+	}
+	if results[0].Team != "red" || results[3].Team != "blue" {
+		t.Error("Results do not carry the team ids")
+	}
+}
+
+func TestAdjudicateTeams(t *testing.T) {
+	state := &mockAdjudicatorState{mockState{[]int{4, 4, 1, 1}}}
+	a := Adjudicate(state, 4, AdjudicatedTurns)
+	if a.Result()[0] != ResultTie {
+		t.Error("Players of different teams sharing first place did not tie")
+	}
+
+	// the adjudicator places both players of the red team first, so red wins
+	teamState := &mockTeamAdjudicatorState{
+		mockAdjudicatorState: mockAdjudicatorState{mockState{[]int{4, 1, 4, 1}}},
+	}
+	teamState.SetTeams(Teams{"red", "blue", "red", "blue"})
+	a = Adjudicate(teamState, 4, AdjudicatedTurns)
+	if !reflect.DeepEqual(a.Result(), []int{1, -1, 1, -1}) {
+		t.Error("Teammates sharing first place did not win")
+	}
+	if Rank(a)[1].Team != "blue" || Rank(a)[1].Reason != AdjudicatedTurns {
+		t.Error("Adjudicated results do not carry the team ids")
+	}
+}
+
+func TestVerifyTeams(t *testing.T) {
+	teams := Teams{"red", "blue", "red", "blue"}
+	buf := new(bytes.Buffer)
+	r := &ActionRecorder{nil, json.NewEncoder(buf)}
+	seed := int64(0)
+	r.enc.Encode(actionLogEntry{Seed: &seed})
+	r.SetTeams(teams)
+
+	state := &mockTeamState{mockState: mockState{make([]int, 4)}}
+	state.SetTeams(teams)
+	actions := []json.RawMessage{
+		StringAction("1"), StringAction("2"), StringAction("3"), StringAction("1"),
+	}
+	for i := 0; !state.Finished(); i++ {
+		for p, a := range actions {
+			Commit(state, p, a)
+		}
+		r.LogTurn(takeTurn(i, actions, nil, state))
+	}
+	r.LogResult(state)
+	log := buf.String()
+
+	newState := func(int64) GameState {
+		return &mockTeamState{mockState: mockState{make([]int, 4)}}
+	}
+	err := Verify(newState, bytes.NewBufferString(log))
+	if err != nil {
+		t.Error(err)
+	}
+
+	tampered := bytes.Replace(
+		[]byte(log), []byte(`"red","blue","red"`), []byte(`"red","red","blue"`), 1,
+	)
+	err = Verify(newState, bytes.NewReader(tampered))
+	if err == nil {
+		t.Error("Tampered teams were not detected")
+	}
+}
@@ -0,0 +1,147 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"golang.org/x/net/websocket"
+	"log"
+	"sync"
+	"time"
+)
+
+// A game state where players take turns making moves one at a time, e.g.,
+// chess or Connect Four. The state decides whose turn it is.
+type TurnBasedGameState interface {
+	GameState
+
+	// Return the index of the player who must make the next move.
+	Turn() int
+}
+
+// Turn-based games use the same clients as synchronized games, the only
+// difference is in how the state manager talks to them.
+type TurnBasedStateManager struct {
+	state   TurnBasedGameState
+	timeout time.Duration
+	control TimeControl
+	updates bool
+	limits  Limits
+}
+
+// Create a new turn-based state manager. If updates is true, then players who
+// are waiting for their turn will be sent the state every time it changes.
+func NewTurnBasedStateManager(
+	game TurnBasedGameState, timeout time.Duration, updates bool,
+) *TurnBasedStateManager {
+	return &TurnBasedStateManager{
+		game, timeout, FixedTimeControl(timeout), updates, DefaultLimits(),
+	}
+}
+
+// Play the game with the given time controls instead of a fixed timeout for
+// every move. Must be called before any clients are created.
+func (m *TurnBasedStateManager) SetTimeControl(control TimeControl) {
+	m.control = control
+}
+
+// Stop the match at the given limits instead of the default ones.
+func (m *TurnBasedStateManager) SetLimits(limits Limits) {
+	m.limits = limits
+}
+
+func (m *TurnBasedStateManager) NewClient(
+	id string, conn *websocket.Conn,
+) GameClient {
+	return NewSynchronizedGameClient(id, conn, m.timeout, m.control)
+}
+
+// Alternate turns between players. Only the player whose turn it is will be
+// asked for an action. If a player does not make a move in the allotted
+// timeframe, then the empty string is committed as its action and a timeout
+// error is sent along the error channel. Waiting players may optionally be
+// sent update messages which they should not respond to. Stops between turns
+// when the context is done, or when a limit is reached, in which case the last
+// turn holds the adjudicated state.
+func (m *TurnBasedStateManager) Play(
+	ctx context.Context,
+	clients []GameClient,
+	turnChan chan Turn,
+	errChan chan error,
+) *sync.WaitGroup {
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		eliminated := make([]bool, len(clients))
+		started := time.Now()
+		for turn := 0; !m.state.Finished() && ctx.Err() == nil; turn++ {
+			i := m.state.Turn()
+			// anything the client sent while it was not its turn is thrown away,
+			// so it cannot queue up moves in advance
+			action, elapsed := request(
+				ctx, clients[i], stateMessage(m.state, clients[i], turn, i), errChan,
+			)
+			if ctx.Err() != nil {
+				// the game was aborted while the player was thinking
+				break
+			}
+
+			commit(ctx, m.state, i, clients[i], action, turn, errChan)
+			notifyEliminated(m.state, clients, turn, eliminated)
+			actions := make([]json.RawMessage, len(clients))
+			times := make([]time.Duration, len(clients))
+			actions[i], times[i] = action, elapsed
+			state, stop := m.limits.check(m.state, len(clients), turn, started)
+			select {
+			case turnChan <- takeTurn(turn, actions, times, state):
+			case <-ctx.Done():
+			}
+			log.Println("Committed action.")
+			if stop {
+				break
+			}
+
+			if m.updates {
+				m.update(ctx, clients, turn, errChan)
+			}
+		}
+
+		wg.Done()
+	}()
+
+	return &wg
+}
+
+// Send a state update to every player that is not about to be asked for an
+// action.
+func (m *TurnBasedStateManager) update(
+	ctx context.Context, clients []GameClient, turn int, errChan chan error,
+) {
+	next := -1
+	if !m.state.Finished() {
+		next = m.state.Turn()
+	}
+
+	for i, c := range clients {
+		if i == next {
+			continue
+		}
+		c.Watchdog().SetTimeout(m.timeout)
+		watchCh := c.Watchdog().Watch()
+		select {
+		case c.Send() <- ServerMessage{
+			Type:   MessageState,
+			Turn:   turn,
+			Player: i,
+			State:  freeze(c, m.state.View(i)),
+			Update: true,
+		}:
+		case <-watchCh:
+			report(ctx, errChan, errors.New("Client send timeout"))
+		case <-ctx.Done():
+		}
+		c.Watchdog().Stop()
+	}
+}
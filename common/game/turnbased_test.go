@@ -0,0 +1,235 @@
+package game
+
+import (
+	"context"
+	"golang.org/x/net/websocket"
+	"testing"
+	"time"
+)
+
+func TestTurnBasedStateManager(t *testing.T) {
+	state := &mockTurnState{mockState{[]int{0, 0}}, 0}
+	turnChan := make(chan Turn)
+	errChan := make(chan error)
+	stateMan := NewTurnBasedStateManager(state, time.Second, false)
+
+	clients := []GameClient{
+		stateMan.NewClient("1", nil),
+		stateMan.NewClient("2", nil),
+	}
+
+	wg := stateMan.Play(context.Background(), clients, turnChan, errChan)
+
+	go func() {
+		for i := 0; i < 4; i++ {
+			// player 1 moves first, then player 2
+			msg := <-clients[0].Send()
+			if msg.Player != 0 || msg.Update {
+				t.Error("Player 1 was not asked for an action")
+			}
+			clients[0].Receive() <- ClientMessage{StringAction("1")}
+			<-turnChan
+			msg = <-clients[1].Send()
+			if msg.Player != 1 || msg.Update {
+				t.Error("Player 2 was not asked for an action")
+			}
+			clients[1].Receive() <- ClientMessage{StringAction("3")}
+			<-turnChan
+		}
+	}()
+
+	wg.Wait()
+
+	if !state.Finished() {
+		t.Error("Game did not finish!")
+	}
+
+	result := state.Result()
+
+	if result[0] != ResultLoss {
+		t.Error("Player 1 did not lose")
+	}
+	if result[1] != ResultWin {
+		t.Error("Player 2 did not win")
+	}
+}
+
+func TestTurnBasedLimits(t *testing.T) {
+	state := &mockTurnState{mockState{[]int{0, 0}}, 0}
+	turnChan := make(chan Turn)
+	errChan := make(chan error)
+	stateMan := NewTurnBasedStateManager(state, time.Second, false)
+	stateMan.SetLimits(Limits{Duration: time.Millisecond})
+
+	clients := []GameClient{
+		stateMan.NewClient("1", nil),
+		stateMan.NewClient("2", nil),
+	}
+
+	wg := stateMan.Play(context.Background(), clients, turnChan, errChan)
+
+	go func() {
+		<-clients[0].Send()
+		time.Sleep(time.Millisecond)
+		clients[0].Receive() <- ClientMessage{StringAction("1")}
+	}()
+
+	turn := <-turnChan
+	wg.Wait()
+
+	a, ok := turn.Final.(*AdjudicatedState)
+	if !ok || a.Reason != AdjudicatedDuration {
+		t.Fatal("Game was not adjudicated at the time limit")
+	}
+	if state.Players[0] != 1 {
+		t.Error("Turn in progress was not played to the end")
+	}
+}
+
+func TestTurnBasedUpdates(t *testing.T) {
+	state := &mockTurnState{mockState{[]int{0, 0}}, 0}
+	turnChan := make(chan Turn)
+	errChan := make(chan error)
+	stateMan := NewTurnBasedStateManager(state, time.Second, true)
+
+	clients := []GameClient{
+		stateMan.NewClient("1", nil),
+		stateMan.NewClient("2", nil),
+	}
+
+	wg := stateMan.Play(context.Background(), clients, turnChan, errChan)
+
+	go func() {
+		for i := 0; i < 4; i++ {
+			<-clients[0].Send()
+			clients[0].Receive() <- ClientMessage{StringAction("1")}
+			<-turnChan
+			// player 2 is about to move, so only player 1 gets an update
+			msg := <-clients[0].Send()
+			if !msg.Update {
+				t.Error("Player 1 was not sent an update")
+			}
+
+			<-clients[1].Send()
+			clients[1].Receive() <- ClientMessage{StringAction("3")}
+			<-turnChan
+			// on the last turn the game is over, so both players get updates
+			if state.Finished() {
+				<-clients[0].Send()
+			}
+			msg = <-clients[1].Send()
+			if !msg.Update {
+				t.Error("Player 2 was not sent an update")
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	if state.Players[0] != 4 {
+		t.Error("Player 1 score is not 4")
+	}
+	if state.Players[1] != 12 {
+		t.Error("Player 2 score is not 12")
+	}
+}
+
+func TestTurnBasedGameHandler(t *testing.T) {
+	ids := []string{"id1", "id2"}
+	secrets := []string{"secret1", "secret2"}
+
+	connMan := NewSimpleConnectionManager()
+	state := &mockTurnState{mockState{[]int{0, 0}}, 0}
+	stateMan := NewTurnBasedStateManager(state, time.Second, false)
+	clientMan := NewAuthenticatedClientManager(
+		stateMan.NewClient,
+		ids,
+		secrets,
+		time.Second,
+	)
+	recorder := &mockGameRecorder{}
+
+	handler, done := GameHandler(
+		context.Background(),
+		connMan,
+		clientMan,
+		stateMan,
+		recorder,
+	)
+
+	url, ts := setupTestServer(handler)
+	defer ts.Close()
+	origin := "http://localhost/"
+	conns := []*websocket.Conn{}
+	for i := 0; i < 2; i++ {
+		config, err := websocket.NewConfig(url, origin)
+		if err != nil {
+			t.Error(err)
+		}
+		config.Header.Add("Authorization", secrets[i])
+		conn, err := websocket.DialConfig(config)
+		if err != nil {
+			t.Error(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+
+	// Simulate two players taking turns
+	go func() {
+		for i := 0; i < 4; i++ {
+			var msg ServerMessage
+			err := websocket.JSON.Receive(conns[0], &msg)
+			if err != nil {
+				t.Error(err)
+			}
+			broadcast := ClientMessage{Action: StringAction("1")}
+			err = websocket.JSON.Send(conns[0], &broadcast)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	go func() {
+		for i := 0; i < 4; i++ {
+			var msg ServerMessage
+			err := websocket.JSON.Receive(conns[1], &msg)
+			if err != nil {
+				t.Error(err)
+			}
+			broadcast := ClientMessage{Action: StringAction("3")}
+			err = websocket.JSON.Send(conns[1], &broadcast)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	<-done
+
+	if !state.Finished() {
+		t.Error("Game did not finish!")
+	}
+	if state.Players[0] != 4 {
+		t.Error("Player 1 score is not 4")
+	}
+	if state.Players[1] != 12 {
+		t.Error("Player 2 score is not 12")
+	}
+}
+
+// A mock game where players alternate turns.
+type mockTurnState struct {
+	mockState
+	turn int
+}
+
+func (s *mockTurnState) Turn() int {
+	return s.turn
+}
+
+func (s *mockTurnState) Do(p int, a string) {
+	s.mockState.Do(p, a)
+	s.turn = (s.turn + 1) % len(s.Players)
+}
@@ -0,0 +1,51 @@
+package tron
+
+import (
+	"errors"
+	"github.com/crestonbunch/botbox/common/game"
+	"math/rand"
+	"strconv"
+)
+
+// The name Tron is registered under.
+const Name = "tron"
+
+// The smallest and largest boards a match can be played on.
+const (
+	MinSize = 4
+	MaxSize = 256
+)
+
+// The settings of a Tron match, including its time controls.
+type Settings struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	game.TimeSettings
+}
+
+// Check that the board is a size that can be played on.
+func (s *Settings) Validate() error {
+	if s.Width < MinSize || s.Width > MaxSize ||
+		s.Height < MinSize || s.Height > MaxSize {
+		return errors.New("Board must be between " + strconv.Itoa(MinSize) +
+			" and " + strconv.Itoa(MaxSize) + " cells on each side.")
+	}
+	return nil
+}
+
+// Tron is registered when the package is imported so that it can be served
+// by name.
+func init() {
+	game.Register(game.Definition{
+		Name:    Name,
+		Players: 2,
+		Manager: game.ManagerSynchronized,
+		Settings: func() interface{} {
+			return &Settings{Width: 32, Height: 32}
+		},
+		NewState: func(settings interface{}, rng *rand.Rand) game.GameState {
+			s := settings.(*Settings)
+			return NewTwoPlayerTron(s.Width, s.Height)
+		},
+	})
+}
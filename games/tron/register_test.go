@@ -0,0 +1,75 @@
+package tron
+
+import (
+	"github.com/crestonbunch/botbox/common/game"
+	"github.com/crestonbunch/botbox/common/game/gametest"
+	"testing"
+	"time"
+)
+
+func TestRegistered(t *testing.T) {
+	d, err := game.Lookup(Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Players != 2 || d.Manager != game.ManagerSynchronized {
+		t.Error("Tron is not a synchronized two player game")
+	}
+
+	settings, err := d.ParseSettings(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := d.NewState(settings, game.NewRand(1)).(*TronState)
+	if state.Width != 32 || state.Height != 32 {
+		t.Error("Default board is not 32x32")
+	}
+
+	settings, err = d.ParseSettings([]byte(`{"width": 64, "height": 48}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state = d.NewState(settings, game.NewRand(1)).(*TronState)
+	if state.Width != 64 || state.Height != 48 {
+		t.Error("Board was not built from the settings")
+	}
+}
+
+func TestSettings(t *testing.T) {
+	d, err := game.Lookup(Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, doc := range []string{
+		`{"width": 2}`,
+		`{"height": 1000}`,
+		`{"move_timeout": -5}`,
+		`{"increment": 100}`,
+	} {
+		_, err := d.ParseSettings([]byte(doc))
+		if err == nil {
+			t.Error("Invalid settings were accepted: " + doc)
+		}
+	}
+
+	settings, err := d.ParseSettings(
+		[]byte(`{"bank": 60000, "increment": 500, "move_timeout": 2000}`),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	control := settings.(game.TimedSettings).Times().TimeControl()
+	if control.Bank != 60*time.Second || control.Increment != 500*time.Millisecond ||
+		control.Move != 2*time.Second {
+		t.Error("Time controls were not read from the settings")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	d, err := game.Lookup(Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gametest.Run(t, gametest.Definition(d))
+}
@@ -8,6 +8,11 @@ WS_SERVER_SCHEME = 'ws'
 WS_SERVER_URL = 'localhost'
 WS_SERVER_PORT = '12345'
 
+# The protocol this SDK speaks, and how it introduces itself to the server.
+PROTOCOL_VERSION = '1.0.0'
+SDK_NAME = 'botbox-tron'
+SDK_VERSION = '1.0'
+
 def safe_moves(p, state):
     """Determine what moves are safe for a player to make. Returns a list of
     valid actions that player p can make in the given state."""
@@ -45,8 +50,10 @@ def start(turn_handler):
         headers = []
 
     # get the URL for the server from an environment variable if it is set,
-    # otherwise use the default localhost
-    if os.environ.get('BOTBOX_SERVER'):
+    # otherwise use the default localhost. The server may include a port.
+    if os.environ.get('BOTBOX_SERVER') and ':' in os.environ['BOTBOX_SERVER']:
+        url = WS_SERVER_SCHEME + '://' + os.environ['BOTBOX_SERVER']
+    elif os.environ.get('BOTBOX_SERVER'):
         url = (WS_SERVER_SCHEME + '://'
             + os.environ['BOTBOX_SERVER'] + ':' + WS_SERVER_PORT)
     else:
@@ -84,18 +91,43 @@ def _on_message(ws, msg, turn_handler):
     the websocket, passes the turn information to an agent's turn
     handler, and then passes the result back to the server."""
 
-    def x():
-        parsed = json.loads(msg)
-        player = parsed['player']
-        actions = parsed['actions']
-        state = parsed['state']
-
-        action = turn_handler(player, actions, state)
-        response = {"action":action}
-
-        ws.send(json.dumps(response))
-
-    _thread.start_new_thread(x, ())
+    _thread.start_new_thread(_handle, (ws, msg, turn_handler))
+
+def _handle(ws, msg, turn_handler):
+    """Respond to a single message from the server. Only state messages that
+    are not updates are answered with an action."""
+
+    parsed = json.loads(msg)
+    kind = parsed.get('type', 'state')
+    if kind == 'hello':
+        # tell the server which protocol we speak before the game starts
+        print('Playing ' + parsed.get('game', '') + ' as player '
+            + str(parsed.get('player')))
+        ws.send(json.dumps({
+            'sdk': SDK_NAME,
+            'sdk_version': SDK_VERSION,
+            'version': PROTOCOL_VERSION,
+        }))
+        return
+    if kind != 'state':
+        # warnings and errors from the server do not need a response
+        print(kind.capitalize() + ' on turn ' + str(parsed.get('turn', 0))
+            + ' (' + parsed.get('code', '') + '): '
+            + parsed.get('message', ''))
+        return
+    if parsed.get('update'):
+        # updates only say what happened while it was another player's turn,
+        # and replying to them would count against the message budget
+        return
+
+    player = parsed['player']
+    actions = parsed.get('actions', [])
+    state = parsed['state']
+
+    action = turn_handler(player, actions, state)
+    response = {"action":action}
+
+    ws.send(json.dumps(response))
 
 def _on_open(ws):
     print('Connection opened')
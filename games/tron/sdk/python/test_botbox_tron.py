@@ -0,0 +1,54 @@
+import json
+import sys
+import types
+import unittest
+
+# the websocket client is only needed to connect, not to handle messages
+sys.modules.setdefault('websocket', types.ModuleType('websocket'))
+
+import botbox_tron
+
+class FakeSocket:
+    def __init__(self):
+        self.sent = []
+
+    def send(self, msg):
+        self.sent.append(json.loads(msg))
+
+class HandleTest(unittest.TestCase):
+    def handle(self, msg):
+        ws = FakeSocket()
+        turns = []
+        def turn_handler(player, actions, state):
+            turns.append(player)
+            return 'north'
+        botbox_tron._handle(ws, json.dumps(msg), turn_handler)
+        return ws.sent, turns
+
+    def test_state(self):
+        sent, turns = self.handle({
+            'type': 'state', 'turn': 3, 'player': 1,
+            'actions': ['north'], 'state': {},
+        })
+        self.assertEqual(sent, [{'action': 'north'}])
+        self.assertEqual(turns, [1])
+
+    def test_update(self):
+        sent, turns = self.handle({
+            'type': 'state', 'turn': 3, 'player': 1, 'state': {},
+            'update': True,
+        })
+        self.assertEqual(sent, [])
+        self.assertEqual(turns, [])
+
+    def test_hello(self):
+        sent, turns = self.handle({'type': 'hello', 'game': 'tron', 'player': 0})
+        self.assertEqual(sent[0]['version'], botbox_tron.PROTOCOL_VERSION)
+        self.assertEqual(turns, [])
+
+    def test_warning(self):
+        sent, turns = self.handle({'type': 'warning', 'message': 'slow'})
+        self.assertEqual(sent, [])
+
+if __name__ == '__main__':
+    unittest.main()
@@ -3,7 +3,7 @@ package main
 import (
 	"github.com/crestonbunch/botbox/common/game"
 	"github.com/crestonbunch/botbox/games/tron"
-	"golang.org/x/net/websocket"
+	"log"
 )
 
 // Setup the tron server to listen to clients.
@@ -17,32 +17,11 @@ import (
 // as two separate agents. Each client that connects should be given a secret
 // but not told what any other secrets are.
 func main() {
+	// importing the tron package registers it
+	d, err := game.Lookup(tron.Name)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	exitChan := make(chan bool)
-
-	go func() {
-		game.RunAuthenticatedServer(
-			func(idList, secretList []string) (websocket.Handler, error) {
-				writer, err := game.NewSimpleGameRecorder("./")
-				if err != nil {
-					return nil, err
-				}
-				stateMan := game.NewSynchronizedStateManager(
-					tron.NewTwoPlayerTron(32, 32), game.MoveTimeout,
-				)
-
-				return game.GameHandler(
-					exitChan,
-					game.NewSimpleConnectionManager(),
-					game.NewAuthenticatedClientManager(
-						stateMan.NewClient, idList, secretList, game.ConnTimeout,
-					),
-					stateMan,
-					writer,
-				), nil
-			},
-		)
-	}()
-
-	<-exitChan
+	game.RunGameServer(d)
 }
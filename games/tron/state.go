@@ -4,6 +4,7 @@ package tron
 
 import (
 	"github.com/crestonbunch/botbox/common/game"
+	"github.com/crestonbunch/botbox/common/game/replay"
 	"strconv"
 )
 
@@ -81,8 +82,8 @@ func (s *TronState) Actions(p int) interface{} {
 	return a
 }
 
-// Commit an action for a player. The direction will be sent as a generic
-// interface, and casted into the expected string
+// Commit an action for a player. Tron actions are plain direction strings, so
+// the state is a game.StringActor and actions are decoded by game.Commit.
 func (s *TronState) Do(p int, a string) {
 
 	// to the integer to string conversion for coordinates
@@ -136,6 +137,50 @@ func (s *TronState) Do(p int, a string) {
 	}
 }
 
+// Place the players by their result, and score them by the length of their
+// trail, so that a crash late in the game scores more than an early one.
+func (s *TronState) Rank() []replay.Result {
+	results := replay.Places(s.Result())
+	for i := range results {
+		score := float64(s.Trail(i))
+		results[i].Score = &score
+		if s.Eliminated(i) {
+			results[i].Reason = "crashed"
+		}
+	}
+	return results
+}
+
+// Matches that are stopped before anyone crashes are won by the longest trail,
+// and players with trails of the same length tie.
+func (s *TronState) Adjudicate() []replay.Result {
+	results := make([]replay.Result, len(s.Players))
+	for i := range s.Players {
+		score := float64(s.Trail(i))
+		results[i].Place = 1
+		results[i].Score = &score
+		for j := range s.Players {
+			if s.Trail(j) > s.Trail(i) {
+				results[i].Place++
+			}
+		}
+	}
+	return results
+}
+
+// Count the cells in the trail of a player.
+func (s *TronState) Trail(p int) int {
+	n := 0
+	for _, column := range s.Cells {
+		for _, q := range column {
+			if q == p {
+				n++
+			}
+		}
+	}
+	return n
+}
+
 // Tron is a perfect-information game, so return the state regardless of player.
 func (s *TronState) View(p int) interface{} {
 	return s
@@ -162,6 +207,17 @@ func (s *TronState) Validate(p int, a string) bool {
 	return false
 }
 
+// In real-time games a player who does not respond keeps traveling in the
+// same direction.
+func (s *TronState) Default(p int) string {
+	return s.Directions[p]
+}
+
+// Dead players are out of the game.
+func (s *TronState) Eliminated(p int) bool {
+	return s.Players[p].X == -1 && s.Players[p].Y == -1
+}
+
 // Kill a player.
 func (s *TronState) Kill(p int) {
 	s.Players[p].X = -1
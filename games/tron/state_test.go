@@ -124,6 +124,41 @@ func TestPlayer1Crashes(t *testing.T) {
 	}
 }
 
+func TestRank(t *testing.T) {
+	state := NewTwoPlayerTron(5, 5)
+	state.Do(0, "south")
+	state.Do(1, "north")
+	state.Do(0, "south")
+	state.Do(1, "south")
+
+	results := game.Rank(state)
+	if results[0].Place != 1 || *results[0].Score != 2 || results[0].Reason != "" {
+		t.Error("Player 1 was not placed first with a trail of 2")
+	}
+	if results[1].Place != 2 || *results[1].Score != 1 ||
+		results[1].Reason != "crashed" {
+		t.Error("Player 2 was not placed second with a trail of 1")
+	}
+}
+
+func TestAdjudicate(t *testing.T) {
+	state := NewTwoPlayerTron(5, 5)
+	state.Do(0, "south")
+	state.Do(1, "north")
+	state.Do(0, "south")
+
+	result := game.Adjudicate(state, 2, game.AdjudicatedTurns).Result()
+	if result[0] != game.ResultWin || result[1] != game.ResultLoss {
+		t.Error("Player 1 did not win with the longest trail")
+	}
+
+	state.Do(1, "north")
+	result = game.Adjudicate(state, 2, game.AdjudicatedTurns).Result()
+	if result[0] != game.ResultTie || result[1] != game.ResultTie {
+		t.Error("Players with trails of the same length did not tie")
+	}
+}
+
 func TestPlayersTie(t *testing.T) {
 	state := NewTwoPlayerTron(5, 5)
 	state.Do(0, "south")
@@ -149,3 +184,52 @@ func TestPlayersTie(t *testing.T) {
 		t.Error("Player 2 did not tie!")
 	}
 }
+
+func TestDefaultAction(t *testing.T) {
+	state := NewTwoPlayerTron(32, 32)
+	state.Do(0, state.Default(0))
+	state.Do(1, state.Default(1))
+
+	if state.Finished() {
+		t.Error("Game is over!")
+	}
+	if state.Players[0].X != 0 || state.Players[0].Y != 1 {
+		t.Error("Player 1 is not at (0,1)")
+	}
+	if state.Players[1].X != 31 || state.Players[1].Y != 30 {
+		t.Error("Player 2 is not at (31,30)")
+	}
+}
+
+func TestCommitJSONAction(t *testing.T) {
+	state := NewTwoPlayerTron(32, 32)
+
+	err := game.Commit(state, 0, []byte(`"east"`))
+	if err != nil {
+		t.Error(err)
+	}
+	err = game.Commit(state, 1, []byte(`{"direction": "north"}`))
+	if _, ok := err.(game.MalformedActionError); !ok {
+		t.Error("Malformed action was not reported")
+	}
+
+	if state.Players[0].X != 1 || state.Players[0].Y != 0 {
+		t.Error("Player 1 is not at (1,0)")
+	}
+	if !state.Finished() {
+		t.Error("Player 2 was not killed for a malformed action")
+	}
+}
+
+func TestEliminated(t *testing.T) {
+	state := NewTwoPlayerTron(32, 32)
+	state.Do(0, "south")
+	state.Do(1, "south")
+
+	if state.Eliminated(0) {
+		t.Error("Player 1 was eliminated")
+	}
+	if !state.Eliminated(1) {
+		t.Error("Player 2 was not eliminated")
+	}
+}
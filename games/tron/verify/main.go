@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"github.com/crestonbunch/botbox/common/game"
+	"github.com/crestonbunch/botbox/games/tron"
+	"github.com/crestonbunch/botbox/services/sandbox"
+	"log"
+	"os"
+)
+
+// Verify a recorded Tron game by playing the logged actions again and
+// comparing every state and the result with the recording. Exits with a
+// non-zero status if the recording does not match, e.g.,
+// go run main.go --log ../server/actions.log
+// Games played with settings must be verified with the same settings.
+func main() {
+	path := flag.String("log", sandbox.ActionLogFile, "The action log to verify.")
+	doc := flag.String("settings", "", "The JSON settings the game was played with.")
+	flag.Parse()
+
+	d, err := game.Lookup(tron.Name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	settings, err := d.ParseSettings([]byte(*doc))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.Open(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	err = game.Verify(func(seed int64) game.GameState {
+		return d.NewState(settings, game.NewRand(seed))
+	}, f)
+	if err != nil {
+		log.Fatal("Verification failed: " + err.Error())
+	}
+
+	log.Println("Verified.")
+}
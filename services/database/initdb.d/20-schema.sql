@@ -220,14 +220,21 @@ CREATE TABLE matches (
 );
 
 /* A mapping table of agents and the matches they played in. Also
- * tracks the result (win, loss, tie) of the agent, and any logs
- * to STDIN/STDOUT printed by the agent during the match.
+ * tracks the result (win, loss, tie) of the agent, the place it
+ * finished in starting at 1, an optional score and reason for the
+ * result given by the game, the team of the agent in matches played
+ * in teams, and any logs to STDIN/STDOUT printed by the agent during
+ * the match.
  */
 CREATE TABLE match_agents (
     "id"       serial,
     "match"    integer REFERENCES matches (id) ON DELETE CASCADE,
     "agent"    integer REFERENCES agents (id) ON DELETE CASCADE,
     "result"   integer,
+    "place"    integer,
+    "score"    double precision,
+    "reason"   text,
+    "team"     text,
     "logs"     text
 );
 
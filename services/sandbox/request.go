@@ -1,6 +1,7 @@
 package sandbox
 
 import (
+	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
@@ -11,18 +12,28 @@ const SetupMemUsage = 100000 // bytes
 // A request to start a match with readers to the directories for starting the
 // match. A list of client unique Ids must be passed in the same order as a
 // list of zip files that contain the agent scripts for each id. Each request
-// must be a multipart-form encoded request.
+// must be a multipart-form encoded request. Instead of a server archive, the
+// name of a game registered with the generic game server can be given.
 type MatchRequest struct {
-	// Passed in the 'server' property
+	// Passed in the 'server' property, nil if a game is given
 	Server Archive
+	// Passed in the 'game' property
+	Game string
+	// A JSON document of game settings, passed in the 'settings' property. The
+	// game validates it when the match starts.
+	Settings string
 	// Passed in the 'ids' property
 	Ids []string
+	// The team id of every client, passed in the 'teams' property. Empty if the
+	// match is not played in teams.
+	Teams []string
 	// Passed in the 'clients' property
 	Clients []Archive
 }
 
 // Build the request from an HTTP multipart/form POST request. The request must
-// contain a single server .zip file and a list of client .zip files.
+// contain a single server .zip file or a game name, and a list of client .zip
+// files.
 // Remember to Close() the MatchRequest when you're done with it!
 func FromHttp(r *http.Request) (*MatchRequest, error) {
 	if r.Method != http.MethodPost {
@@ -37,9 +48,24 @@ func FromHttp(r *http.Request) (*MatchRequest, error) {
 	serverFiles := m.File["server"]
 	clientFiles := m.File["clients"]
 
-	if len(serverFiles) == 0 {
+	game := ""
+	if len(m.Value["game"]) > 0 {
+		game = m.Value["game"][0]
+	}
+	settings := ""
+	if len(m.Value["settings"]) > 0 {
+		settings = m.Value["settings"][0]
+	}
+	if settings != "" && !json.Valid([]byte(settings)) {
+		return nil, errors.New("Settings must be a JSON document.")
+	}
+
+	if len(serverFiles) == 0 && game == "" {
 		return nil, errors.New("Missing server file.")
 	}
+	if len(serverFiles) > 0 && game != "" {
+		return nil, errors.New("Cannot have both a server file and a game.")
+	}
 	if len(serverFiles) > 1 {
 		return nil, errors.New("Too many server files.")
 	}
@@ -47,18 +73,25 @@ func FromHttp(r *http.Request) (*MatchRequest, error) {
 		return nil, errors.New("Missing client files.")
 	}
 
-	// open the server reader
-	server, err := serverFiles[0].Open()
-	if err != nil && err != io.EOF {
-		return nil, err
-	}
+	var serverArchive Archive
+	if len(serverFiles) > 0 {
+		// open the server reader
+		server, err := serverFiles[0].Open()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
 
-	serverArchive, err := OpenArchive(server)
-	if err != nil {
-		return nil, err
+		serverArchive, err = OpenArchive(server)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	clientIds := m.Value["ids"]
+	teams := m.Value["teams"]
+	if len(teams) > 0 && len(teams) != len(clientFiles) {
+		return nil, errors.New("Every client needs a team.")
+	}
 
 	clientArchives := []Archive{}
 	// open the client readers
@@ -74,5 +107,7 @@ func FromHttp(r *http.Request) (*MatchRequest, error) {
 		clientArchives = append(clientArchives, archive)
 	}
 
-	return &MatchRequest{serverArchive, clientIds, clientArchives}, nil
+	return &MatchRequest{
+		serverArchive, game, settings, clientIds, teams, clientArchives,
+	}, nil
 }
@@ -111,3 +111,112 @@ func TestGoodRequest(t *testing.T) {
 		t.Error("Request does not have 2 clients.")
 	}
 }
+
+func TestGameRequest(t *testing.T) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	agentZip := new(bytes.Buffer)
+	agentWriter := zip.NewWriter(agentZip)
+	w, err := agentWriter.Create("__init__.py")
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = w.Write([]byte(random_agent))
+	if err != nil {
+		t.Error(err)
+	}
+	agentWriter.Close()
+
+	err = multipartAddField(writer, "game", "tron")
+	if err != nil {
+		t.Error(err)
+	}
+	err = multipartAddField(writer, "settings", `{"width": 64}`)
+	if err != nil {
+		t.Error(err)
+	}
+	err = multipartAddFile(writer, "clients", "random_agent.zip", agentZip.Bytes())
+	if err != nil {
+		t.Error(err)
+	}
+	err = multipartAddField(writer, "ids", "id1")
+	if err != nil {
+		t.Error(err)
+	}
+	err = multipartAddField(writer, "teams", "red")
+	if err != nil {
+		t.Error(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Error(err)
+	}
+
+	mockReq, err := http.NewRequest(
+		http.MethodPost,
+		"http://localhost/",
+		body,
+	)
+	if err != nil {
+		t.Error(err)
+	}
+	mockReq.Header.Set("Content-Type", writer.FormDataContentType())
+
+	req, err := FromHttp(mockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Server != nil {
+		t.Error("Request has a server archive.")
+	}
+
+	if req.Game != "tron" {
+		t.Error("Request game is not tron.")
+	}
+
+	if req.Settings != `{"width": 64}` {
+		t.Error("Request does not have the settings.")
+	}
+	if len(req.Teams) != 1 || req.Teams[0] != "red" {
+		t.Error("Request does not have the teams.")
+	}
+}
+
+func TestTeamsRequest(t *testing.T) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	err := multipartAddField(writer, "game", "tron")
+	if err != nil {
+		t.Error(err)
+	}
+	err = multipartAddFile(writer, "clients", "agent.zip", []byte{})
+	if err != nil {
+		t.Error(err)
+	}
+	for _, team := range []string{"red", "blue"} {
+		err = multipartAddField(writer, "teams", team)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Error(err)
+	}
+
+	mockReq, err := http.NewRequest(
+		http.MethodPost,
+		"http://localhost/",
+		body,
+	)
+	if err != nil {
+		t.Error(err)
+	}
+	mockReq.Header.Set("Content-Type", writer.FormDataContentType())
+
+	_, err = FromHttp(mockReq)
+	if err == nil || err.Error() != "Every client needs a team." {
+		t.Error("Request with more teams than clients was accepted.")
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"github.com/crestonbunch/botbox/common/game/replay"
 	"github.com/docker/engine-api/client"
 	"github.com/docker/engine-api/types"
 	"github.com/docker/engine-api/types/container"
@@ -28,6 +30,10 @@ const StateLogFile = "state.log"
 const ResultLogFile = "result.log"
 const ConnectLogFile = "connect.log"
 const DisconnectLogFile = "disconnect.log"
+const ReconnectLogFile = "reconnect.log"
+const ViolationLogFile = "violation.log"
+const ReplayFile = "replay.json.gz"
+const ActionLogFile = "actions.log"
 
 const ServerUser = "sandbox"
 const ClientUser = "sandbox"
@@ -37,6 +43,10 @@ const ClientServerEnvVar = "BOTBOX_SERVER"
 const ClientSecretEnvVar = "BOTBOX_SECRET"
 const ServerIdsEnvVar = "BOTBOX_IDS"
 const ServerSecretEnvVar = "BOTBOX_SECRETS"
+const ServerSeedEnvVar = "BOTBOX_SEED"
+const ServerGameEnvVar = "BOTBOX_GAME"
+const ServerSettingsEnvVar = "BOTBOX_SETTINGS"
+const ServerTeamsEnvVar = "BOTBOX_TEAMS"
 const SecretLength = 64
 const EnvListSep = " "
 
@@ -65,6 +75,17 @@ func tarFile(path string) (io.Reader, error) {
 	return bytes.NewReader(buf.Bytes()), nil
 }
 
+// Generate a random seed for a match. It must not be predictable, otherwise a
+// bot could work out what the game will do.
+func GenerateSeed() (int64, error) {
+	var b [8]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(b[:])), nil
+}
+
 // Generate a list of n cryptographically secure secrets.
 func GenerateSecrets(n int) ([]string, error) {
 	output := make([]string, n)
@@ -206,44 +227,105 @@ func BadClients(cli *client.Client, serverId string) ([]string, error) {
 	return output, nil
 }
 
-// Get the results for each client from the server.
-func GameResult(cli *client.Client, serverId string) ([]int, error) {
-	path := ServerDropDir + "/" + ResultLogFile
+// A client that broke a rule of the connection, e.g., by flooding the server
+// with messages, and the error code of the violation.
+type Violation struct {
+	Client string
+	Code   string
+}
+
+// Get the violations of every client from the violation.log file inside the
+// container.
+func Violations(cli *client.Client, serverId string) ([]Violation, error) {
+	path := ServerDropDir + "/" + ViolationLogFile
 	contents, err := getFile(cli, serverId, path)
 	if err != nil {
 		return nil, err
 	}
 
-	parsed := make([]float64, 0)
-	err = json.Unmarshal(contents, &parsed)
-	if err != nil {
-		return nil, err
-	}
+	output := make([]Violation, 0)
 
-	result := make([]int, len(parsed))
-	for i, v := range parsed {
-		result[i] = int(v)
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			output = append(output, Violation{fields[0], fields[1]})
+		}
 	}
 
-	return result, nil
+	return output, nil
 }
 
-// Get a list of state histories from the state.log file
-func GameHistory(cli *client.Client, serverId string) ([]interface{}, error) {
-	path := ServerDropDir + "/" + StateLogFile
+// Get the result of each client from the server. Servers that only record
+// wins, ties and losses have their players placed by them.
+func GameResult(cli *client.Client, serverId string) ([]replay.Result, error) {
+	path := ServerDropDir + "/" + ResultLogFile
 	contents, err := getFile(cli, serverId, path)
 	if err != nil {
 		return nil, err
 	}
 
-	states, err := bytes.Split(bytes.TrimSpace(contents), []byte("\n")), nil
+	return replay.ParseResults(contents)
+}
+
+// Get the replay of the game from the server.
+func GameReplay(cli *client.Client, serverId string) (*replay.Reader, error) {
+	path := ServerDropDir + "/" + ReplayFile
+	contents, err := getFile(cli, serverId, path)
 	if err != nil {
 		return nil, err
 	}
-	output := make([]interface{}, len(states))
 
-	for i, v := range states {
-		output[i] = json.Unmarshal(v, &output[i])
+	return replay.NewReader(bytes.NewReader(contents))
+}
+
+// Get the list of states the game went through from the replay. Servers built
+// before replays were recorded only wrote a state log, which is read instead.
+func GameHistory(cli *client.Client, serverId string) ([]interface{}, error) {
+	r, err := GameReplay(cli, serverId)
+	if err != nil {
+		path := ServerDropDir + "/" + StateLogFile
+		contents, logErr := getFile(cli, serverId, path)
+		if logErr != nil {
+			return nil, err
+		}
+		return parseStateLog(contents)
+	}
+
+	output := make([]interface{}, 0, r.Len())
+	for {
+		turn, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		var state interface{}
+		err = json.Unmarshal(turn.State, &state)
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, state)
+	}
+
+	return output, nil
+}
+
+// Parse a state log, which has the state of the game after every turn on a line
+// of its own.
+func parseStateLog(contents []byte) ([]interface{}, error) {
+	output := make([]interface{}, 0)
+	for _, line := range bytes.Split(bytes.TrimSpace(contents), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		var state interface{}
+		err := json.Unmarshal(line, &state)
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, state)
 	}
 
 	return output, nil
@@ -292,10 +374,16 @@ func SetupNetwork(cli *client.Client) (string, error) {
 }
 
 // Setup a server sandbox in an isolated container. Returns the ID of the
-// container if it was created successfully.
+// container if it was created successfully. If a game is given, then the
+// container serves that registered game with the generic game server, and the
+// archive may be nil. The settings are passed to the server as a JSON document,
+// and the teams as the team id of every client, or nil if the match is not
+// played in teams.
 func SetupServer(
 	cli *client.Client,
-	ids, secrets []string,
+	ids, secrets, teams []string,
+	seed int64,
+	game, settings string,
 	archive Archive,
 ) (string, error) {
 
@@ -309,6 +397,10 @@ func SetupServer(
 		Env: []string{
 			ServerIdsEnvVar + "=" + strings.Join(ids, EnvListSep),
 			ServerSecretEnvVar + "=" + strings.Join(secrets, EnvListSep),
+			ServerSeedEnvVar + "=" + strconv.FormatInt(seed, 10),
+			ServerGameEnvVar + "=" + game,
+			ServerSettingsEnvVar + "=" + settings,
+			ServerTeamsEnvVar + "=" + strings.Join(teams, EnvListSep),
 		},
 	}
 	// TODO: send score results to scoreboard service
@@ -326,6 +418,9 @@ func SetupServer(
 	if err != nil {
 		return "", err
 	}
+	if archive == nil {
+		return response.ID, nil
+	}
 
 	log.Println("Copying server files.")
 	tar, err := ArchiveToTar(archive)
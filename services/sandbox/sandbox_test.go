@@ -56,33 +56,14 @@ const tron_server = `package main
 import (
 	"github.com/crestonbunch/botbox/common/game"
 	"github.com/crestonbunch/botbox/games/tron"
-	"golang.org/x/net/websocket"
+	"log"
 )
 func main() {
-	exitChan := make(chan bool)
-	go func() {
-		game.RunAuthenticatedServer(
-			func(idList, secretList []string) (websocket.Handler, error) {
-				writer, err := game.NewSimpleGameRecorder("./")
-				if err != nil {
-					return nil, err
-				}
-				stateMan := game.NewSynchronizedStateManager(
-					tron.NewTwoPlayerTron(32, 32), game.MoveTimeout,
-				)
-				return game.GameHandler(
-					exitChan,
-					game.NewSimpleConnectionManager(),
-					game.NewAuthenticatedClientManager(
-						stateMan.NewClient, idList, secretList, game.ConnTimeout,
-					),
-					stateMan,
-					writer,
-				), nil
-			},
-		)
-	}()
-	<-exitChan
+	d, err := game.Lookup(tron.Name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	game.RunGameServer(d)
 }`
 
 const DockerUserAgent = "botbox-game-1.0"
@@ -160,7 +141,7 @@ func TestSetupZipServer(t *testing.T) {
 	// create the server
 	ids := []string{"id1", "id2"}
 	secrets := []string{"secret1", "secret2"}
-	servId, err := SetupServer(cli, ids, secrets, serverArchive)
+	servId, err := SetupServer(cli, ids, secrets, nil, 1, "", "", serverArchive)
 	if err != nil {
 		t.Error(err)
 	}
@@ -248,7 +229,7 @@ func TestSetupZipClients(t *testing.T) {
 	// create the server
 	ids := []string{"id1", "id2"}
 	secrets := []string{"secret1", "secret2"}
-	servId, err := SetupServer(cli, ids, secrets, serverArchive)
+	servId, err := SetupServer(cli, ids, secrets, nil, 1, "", "", serverArchive)
 	if err != nil {
 		t.Error(err)
 	}
@@ -352,7 +333,7 @@ func TestRunClients(t *testing.T) {
 	// create the server
 	ids := []string{"id1", "id2"}
 	secrets := []string{"secret1", "secret2"}
-	servId, err := SetupServer(cli, ids, secrets, serverArchive)
+	servId, err := SetupServer(cli, ids, secrets, nil, 1, "", "", serverArchive)
 	if err != nil {
 		t.Error(err)
 	}
@@ -405,12 +386,20 @@ func TestRunClients(t *testing.T) {
 		t.Error("No clients should have committed a sin!")
 	}
 
+	violations, err := Violations(cli, servId)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(violations) > 0 {
+		t.Error("No clients should have broken a rule!")
+	}
+
 	results, err := GameResult(cli, servId)
 	if err != nil {
 		t.Error(err)
 	}
-	if !(((results[0] == 1 || results[1] == 1) && results[0] != results[1]) ||
-		(results[0] == 0 && results[0] == 0)) {
+	if len(results) != 2 || results[0].Place < 1 || results[1].Place < 1 ||
+		(results[0].Place != 1 && results[1].Place != 1) {
 		t.Error("Game results are not valid.")
 	}
 
@@ -487,7 +476,7 @@ func TestWriteRoot(t *testing.T) {
 	// create the server
 	ids := []string{"id1", "id2"}
 	secrets := []string{"secret1", "secret2"}
-	servId, err := SetupServer(cli, ids, secrets, serverArchive)
+	servId, err := SetupServer(cli, ids, secrets, nil, 1, "", "", serverArchive)
 	if err != nil {
 		t.Error(err)
 	}
@@ -603,7 +592,7 @@ func TestPingServer(t *testing.T) {
 	// create the server
 	ids := []string{"id1", "id2"}
 	secrets := []string{"secret1", "secret2"}
-	servId, err := SetupServer(cli, ids, secrets, serverArchive)
+	servId, err := SetupServer(cli, ids, secrets, nil, 1, "", "", serverArchive)
 	if err != nil {
 		t.Error(err)
 	}
@@ -716,7 +705,7 @@ func TestDestroySandbox(t *testing.T) {
 	// create the server
 	ids := []string{"id1", "id2"}
 	secrets := []string{"secret1", "secret2"}
-	servId, err := SetupServer(cli, ids, secrets, serverArchive)
+	servId, err := SetupServer(cli, ids, secrets, nil, 1, "", "", serverArchive)
 	if err != nil {
 		t.Error(err)
 	}
@@ -769,6 +758,25 @@ func TestDestroySandbox(t *testing.T) {
 	}
 }
 
+func TestParseStateLog(t *testing.T) {
+	history, err := parseStateLog([]byte("{\"turn\":0}\n{\"turn\":1}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[1].(map[string]interface{})["turn"] != 1.0 {
+		t.Error("States were not parsed from the state log")
+	}
+
+	history, err = parseStateLog(nil)
+	if err != nil || len(history) != 0 {
+		t.Error("Empty state log has states")
+	}
+	_, err = parseStateLog([]byte("{\"turn\":"))
+	if err == nil {
+		t.Error("Broken state log was parsed")
+	}
+}
+
 func TestBuildServer(t *testing.T) {
 	_, err := BuildImage(cli, "server/server-image/", "test-image-please-ignore")
 	log.Println(err)
@@ -17,8 +17,10 @@ const ClientDockerFile = "client-image/"
 // Request to start a match. To create a listener, provide a cli interface to
 // a Docker engine, and the HTTP response writer and reader. To start a match
 // send a multipart/form request to the endpoint which contains a "server"
-// entry which is a .zip file for the server and a "clients" entry which is
-// a list of .zip files for each client.
+// entry which is a .zip file for the server, or a "game" entry naming a
+// registered game, and a "clients" entry which is a list of .zip files for each
+// client. An optional "settings" entry holds a JSON document of game settings,
+// and optional "teams" entries hold the team id of every client.
 // TODO: make this a transaction-like approach where if one part of the
 // sandbox fails to start, we clean up what we made so there aren't a bunch of
 // unused docker networks and containers floating around the host
@@ -52,9 +54,21 @@ func matchStarter(cli *client.Client, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Every match gets its own seed, so random games can be reproduced
+	seed, err := sandbox.GenerateSeed()
+	if err != nil {
+		log.Println("Error generating seed.")
+		log.Println(err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
 	// create the server
 	ids := request.Ids
-	servId, err := sandbox.SetupServer(cli, ids, secrets, request.Server)
+	servId, err := sandbox.SetupServer(
+		cli, ids, secrets, request.Teams, seed,
+		request.Game, request.Settings, request.Server,
+	)
 	if err != nil {
 		log.Println("Error setting up server.")
 		log.Println(err)
@@ -1,5 +1,7 @@
 if [ -f "main.go" ]; then
     go run main.go
+elif [ -n "$BOTBOX_GAME" ]; then
+    $GOPATH/bin/botbox-game-server
 else
     echo "No script found to run."
 fi